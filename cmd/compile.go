@@ -0,0 +1,61 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	compileCmd.Flags().StringArrayVarP(&exerciser.Args, "args", "x", []string{}, "String arguments to pack into the calldata for --method (auto-converted to type, JSON for arrays/structs)")
+	compileCmd.Flags().StringVarP(&exerciser.ArgsFile, "args-file", "X", "", "JSON file containing an array of arguments to pack into the calldata for --method")
+	compileCmd.Flags().StringVar(&exerciser.BasePath, "base-path", "", "Base path for solc to resolve imports from (defaults to the directory of --file, with the working directory as an include path, for solc 0.8.8+)")
+	compileCmd.Flags().StringVar(&exerciser.CompileCacheDir, "cache-dir", "", "Directory to cache compiled contracts in (defaults to the user cache directory)")
+	compileCmd.Flags().StringArrayVar(&exerciser.ConstructorArgs, "constructor-args", []string{}, "String arguments to pack for the contract constructor (auto-converted to type)")
+	compileCmd.Flags().StringVarP(&exerciser.ContractName, "contractname", "n", "", "The name of the contract to export, for Solidity files with multiple contracts")
+	compileCmd.Flags().IntVarP(&exerciser.DebugLevel, "debug", "d", 1, "0=error, 1=info, 2=debug")
+	compileCmd.Flags().StringVarP(&exerciser.EVMVersion, "evm-version", "V", "", "EVM version to compile for (defaults to byzantium for solc, and the compiler default for vyper)")
+	compileCmd.Flags().StringVarP(&exerciser.SolidityFile, "file", "f", "", "Solidity (or Vyper .vy) smart contract source to compile")
+	compileCmd.Flags().StringArrayVar(&exerciser.IncludePaths, "include-path", []string{}, "Additional paths for solc to resolve imports from, such as node_modules")
+	compileCmd.Flags().StringVarP(&exerciser.Method, "method", "m", "", "Method name in the contract to pack calldata for, or signature for overloads: 'transfer(address,uint256)'")
+	compileCmd.Flags().BoolVar(&exerciser.NoCompileCache, "no-cache", false, "Recompile the contract, rather than using the compilation cache")
+	compileCmd.Flags().BoolVar(&exerciser.Optimize, "optimize", true, "Enable the solc optimizer")
+	compileCmd.Flags().IntVar(&exerciser.OptimizerRuns, "optimizer-runs", 200, "Number of runs for the solc optimizer to optimize for")
+	compileCmd.Flags().StringVarP(&exerciser.ExportFile, "out", "o", "", "JSON artifact file to write (defaults to stdout)")
+	compileCmd.Flags().StringArrayVar(&exerciser.Remappings, "remap", []string{}, "Import remapping for solc, such as @openzeppelin/=node_modules/@openzeppelin/")
+	compileCmd.Flags().StringVar(&exerciser.SolcPath, "solc", "solc", "Path to the solc binary used to compile --file")
+	compileCmd.Flags().StringVar(&exerciser.SolcReleasesDir, "solc-dir", "", "Directory of solc releases to select from, matching the pragma solidity version of --file (instead of --solc)")
+	compileCmd.Flags().BoolVar(&exerciser.ViaIR, "via-ir", false, "Compile via the solc IR pipeline")
+	compileCmd.Flags().StringVar(&exerciser.VyperPath, "vyper", "vyper", "Path to the vyper binary used to compile .vy files")
+	compileCmd.MarkFlagRequired("file")
+	compileCmd.MarkFlagsMutuallyExclusive("solc", "solc-dir")
+	cmd.AddCommand(compileCmd)
+}
+
+var compileCmd = &cobra.Command{
+	Use:   "compile",
+	Short: "Compile a contract to a JSON artifact, without connecting to a node",
+	Long:  "Compile a contract and export the ABI, bytecode, runtime bytecode, method selectors and packed calldata as a JSON artifact, without connecting to a node",
+	Run: func(cmd *cobra.Command, args []string) {
+		initLogging(exerciser.DebugLevel)
+		if err := exerciser.Export(); err != nil {
+			log.Error("Compile: ", err)
+			os.Exit(1)
+		}
+	},
+}
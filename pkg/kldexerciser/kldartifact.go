@@ -0,0 +1,260 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/compiler"
+	log "github.com/sirupsen/logrus"
+)
+
+// Artifact formats that can be loaded instead of compiling with solc
+const (
+	ArtifactHardhat = "hardhat"
+	ArtifactTruffle = "truffle"
+	ArtifactFoundry = "foundry"
+)
+
+// buildArtifact is a superset of the fields we use from Hardhat, Truffle and Foundry
+// build artifacts. The bytecode fields are strings for Hardhat/Truffle, and objects
+// for Foundry, so are parsed once we have detected the format. The top level
+// immutableReferences are only in the artifacts written by the compile command
+type buildArtifact struct {
+	Format              string            `json:"_format"`
+	SchemaVersion       string            `json:"schemaVersion"`
+	ContractName        string            `json:"contractName"`
+	SourceName          string            `json:"sourceName"`
+	ABI                 interface{}       `json:"abi"`
+	Bytecode            json.RawMessage   `json:"bytecode"`
+	DeployedBytecode    json.RawMessage   `json:"deployedBytecode"`
+	SourceMap           string            `json:"sourceMap"`
+	DeployedSourceMap   string            `json:"deployedSourceMap"`
+	Source              string            `json:"source"`
+	UserDoc             interface{}       `json:"userdoc"`
+	DevDoc              interface{}       `json:"devdoc"`
+	Metadata            json.RawMessage   `json:"metadata"`
+	RawMetadata         string            `json:"rawMetadata"`
+	MethodIdentifiers   map[string]string `json:"methodIdentifiers"`
+	LinkReferences      linkReferences    `json:"linkReferences"`
+	ImmutableReferences []CodeRange       `json:"immutableReferences"`
+	Compiler            struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"compiler"`
+}
+
+type foundryBytecode struct {
+	Object              string                 `json:"object"`
+	SourceMap           string                 `json:"sourceMap"`
+	LinkReferences      linkReferences         `json:"linkReferences"`
+	ImmutableReferences map[string][]CodeRange `json:"immutableReferences"`
+}
+
+// linkReferences are the offsets of library placeholders, keyed by file then library name
+type linkReferences map[string]map[string]json.RawMessage
+
+func (l linkReferences) libraryNames() []string {
+	var names []string
+	for file, libraries := range l {
+		for name := range libraries {
+			names = append(names, file+":"+name)
+		}
+	}
+	return names
+}
+
+// loadedArtifact is the contract loaded from an artifact, with the details needed to link it
+type loadedArtifact struct {
+	ContractName   string
+	Contract       *compiler.Contract
+	LinkReferences []string
+	Immutables     []CodeRange // nil if the artifact does not record the immutable references
+}
+
+type foundryMetadata struct {
+	Compiler struct {
+		Version string `json:"version"`
+	} `json:"compiler"`
+	Settings struct {
+		CompilationTarget map[string]string `json:"compilationTarget"`
+	} `json:"settings"`
+}
+
+// detectArtifactFormat works out which tool generated the artifact
+func detectArtifactFormat(artifact *buildArtifact) (string, error) {
+	if strings.HasPrefix(artifact.Format, "hh-sol-artifact") {
+		return ArtifactHardhat, nil
+	}
+	if artifact.ABI == nil || len(artifact.Bytecode) == 0 {
+		return "", fmt.Errorf("artifact does not contain 'abi' and 'bytecode'")
+	}
+	if strings.HasPrefix(string(artifact.Bytecode), "{") {
+		return ArtifactFoundry, nil
+	}
+	if artifact.SchemaVersion != "" || artifact.ContractName != "" {
+		return ArtifactTruffle, nil
+	}
+	return "", fmt.Errorf("unrecognized artifact format")
+}
+
+func prefixHex(code string) string {
+	if code == "" || strings.HasPrefix(code, "0x") {
+		return code
+	}
+	return "0x" + code
+}
+
+// parseArtifact builds a contract from the Hardhat, Truffle or Foundry artifact JSON
+func parseArtifact(artifactJSON []byte) (*loadedArtifact, error) {
+	var artifact buildArtifact
+	if err := json.Unmarshal(artifactJSON, &artifact); err != nil {
+		return nil, fmt.Errorf("unable to parse: %s", err)
+	}
+	format, err := detectArtifactFormat(&artifact)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Detected %s artifact format", format)
+
+	contract := &compiler.Contract{
+		Info: compiler.ContractInfo{
+			Source:        artifact.Source,
+			Language:      "Solidity",
+			AbiDefinition: artifact.ABI,
+			UserDoc:       artifact.UserDoc,
+			DeveloperDoc:  artifact.DevDoc,
+		},
+	}
+	loaded := &loadedArtifact{
+		ContractName: artifact.ContractName,
+		Contract:     contract,
+	}
+
+	switch format {
+	case ArtifactFoundry:
+		var bytecode, deployedBytecode foundryBytecode
+		if err = json.Unmarshal(artifact.Bytecode, &bytecode); err != nil {
+			return nil, fmt.Errorf("invalid bytecode: %s", err)
+		}
+		if len(artifact.DeployedBytecode) > 0 {
+			if err = json.Unmarshal(artifact.DeployedBytecode, &deployedBytecode); err != nil {
+				return nil, fmt.Errorf("invalid deployedBytecode: %s", err)
+			}
+		}
+		contract.Code = prefixHex(bytecode.Object)
+		contract.RuntimeCode = prefixHex(deployedBytecode.Object)
+		contract.Hashes = artifact.MethodIdentifiers
+		loaded.LinkReferences = bytecode.LinkReferences.libraryNames()
+		contract.Info.SrcMap = bytecode.SourceMap
+		contract.Info.SrcMapRuntime = deployedBytecode.SourceMap
+		contract.Info.Metadata = artifact.RawMetadata
+		if deployedBytecode.ImmutableReferences != nil {
+			loaded.Immutables = []CodeRange{}
+		}
+		for _, refs := range deployedBytecode.ImmutableReferences {
+			loaded.Immutables = append(loaded.Immutables, refs...)
+		}
+		var metadata foundryMetadata
+		if len(artifact.Metadata) > 0 && json.Unmarshal(artifact.Metadata, &metadata) == nil {
+			contract.Info.CompilerVersion = metadata.Compiler.Version
+			for _, name := range metadata.Settings.CompilationTarget {
+				loaded.ContractName = name
+			}
+		}
+	default:
+		var bytecode, deployedBytecode string
+		if err = json.Unmarshal(artifact.Bytecode, &bytecode); err != nil {
+			return nil, fmt.Errorf("invalid bytecode: %s", err)
+		}
+		if len(artifact.DeployedBytecode) > 0 {
+			if err = json.Unmarshal(artifact.DeployedBytecode, &deployedBytecode); err != nil {
+				return nil, fmt.Errorf("invalid deployedBytecode: %s", err)
+			}
+		}
+		contract.Code = prefixHex(bytecode)
+		contract.RuntimeCode = prefixHex(deployedBytecode)
+		contract.Info.SrcMap = artifact.SourceMap
+		contract.Info.SrcMapRuntime = artifact.DeployedSourceMap
+		contract.Info.CompilerVersion = artifact.Compiler.Version
+		loaded.LinkReferences = artifact.LinkReferences.libraryNames()
+		loaded.Immutables = artifact.ImmutableReferences
+		if artifact.Compiler.Name == "vyper" {
+			contract.Info.Language = "Vyper"
+		}
+		// Truffle stores the metadata as a JSON string, Hardhat does not include it
+		var metadata string
+		if len(artifact.Metadata) > 0 && json.Unmarshal(artifact.Metadata, &metadata) == nil {
+			contract.Info.Metadata = metadata
+		}
+	}
+	contract.Info.LanguageVersion = contract.Info.CompilerVersion
+
+	if contract.Code == "" || contract.Code == "0x" {
+		return nil, fmt.Errorf("artifact contains no bytecode (abstract contract or interface?)")
+	}
+	return loaded, nil
+}
+
+// LoadArtifact loads a precompiled Hardhat, Truffle or Foundry artifact, rather than invoking solc
+func LoadArtifact(artifactFile, contractName, method string, args []interface{}) (*CompiledSolidity, error) {
+	artifactJSON, err := ioutil.ReadFile(artifactFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read artifact %s: %s", artifactFile, err)
+	}
+	loaded, err := parseArtifact(artifactJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load artifact %s: %s", artifactFile, err)
+	}
+	if contractName != "" && loaded.ContractName != "" && contractName != loaded.ContractName {
+		return nil, fmt.Errorf("artifact %s contains contract %s, not %s", artifactFile, loaded.ContractName, contractName)
+	}
+	c, err := newCompiledSolidity(loaded.Contract, method, args)
+	if err != nil {
+		return nil, err
+	}
+	c.Name = loaded.ContractName
+	c.LinkReferences = loaded.LinkReferences
+	c.Immutables = loaded.Immutables
+	return c, nil
+}
+
+// LoadABI loads a JSON ABI, for calling a pre-deployed contract without its source.
+// The file can contain the ABI array itself, or an object with an "abi" field
+func LoadABI(abiFile, method string, args []interface{}) (*CompiledSolidity, error) {
+	abiJSON, err := ioutil.ReadFile(abiFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ABI %s: %s", abiFile, err)
+	}
+	var abiDefinition interface{}
+	if err = json.Unmarshal(abiJSON, &abiDefinition); err != nil {
+		return nil, fmt.Errorf("unable to parse ABI %s: %s", abiFile, err)
+	}
+	if wrapper, ok := abiDefinition.(map[string]interface{}); ok {
+		abiDefinition = wrapper["abi"]
+	}
+	if _, ok := abiDefinition.([]interface{}); !ok {
+		return nil, fmt.Errorf("ABI %s must contain a JSON array, or an object with an 'abi' array", abiFile)
+	}
+	contract := &compiler.Contract{
+		Info: compiler.ContractInfo{
+			AbiDefinition: abiDefinition,
+		},
+	}
+	return newCompiledSolidity(contract, method, args)
+}
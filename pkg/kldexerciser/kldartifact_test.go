@@ -0,0 +1,182 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testArtifactABI = `[{"type": "function", "name": "set", "stateMutability": "nonpayable",
+	"inputs": [{"name": "x", "type": "uint256"}], "outputs": []}]`
+
+const testHardhatArtifact = `{
+	"_format": "hh-sol-artifact-1",
+	"contractName": "Store",
+	"sourceName": "contracts/Store.sol",
+	"abi": ` + testArtifactABI + `,
+	"bytecode": "0x6080",
+	"deployedBytecode": "0x6001",
+	"linkReferences": {"contracts/Lib.sol": {"Lib": [{"start": 1, "length": 20}]}},
+	"deployedLinkReferences": {}
+}`
+
+const testTruffleArtifact = `{
+	"contractName": "Store",
+	"schemaVersion": "3.4.11",
+	"abi": ` + testArtifactABI + `,
+	"bytecode": "0x6080",
+	"deployedBytecode": "0x6001",
+	"sourceMap": "1:2:0:-",
+	"deployedSourceMap": "3:4:0:-",
+	"source": "contract Store {}",
+	"metadata": "{\"compiler\":{\"version\":\"0.8.19+commit.7dd6d404\"}}",
+	"compiler": {"name": "solc", "version": "0.8.19+commit.7dd6d404.Emscripten.clang"}
+}`
+
+const testFoundryArtifact = `{
+	"abi": ` + testArtifactABI + `,
+	"bytecode": {"object": "6080", "sourceMap": "1:2:0:-", "linkReferences": {"src/Lib.sol": {"Lib": [{"start": 1, "length": 20}]}}},
+	"deployedBytecode": {"object": "0x6001", "sourceMap": "3:4:0:-",
+		"immutableReferences": {"5": [{"start": 10, "length": 32}, {"start": 50, "length": 32}]}},
+	"methodIdentifiers": {"set(uint256)": "60fe47b1"},
+	"rawMetadata": "{}",
+	"metadata": {"compiler": {"version": "0.8.19+commit.7dd6d404"}, "settings": {"compilationTarget": {"src/Store.sol": "Store"}}}
+}`
+
+func TestParseArtifactFormats(t *testing.T) {
+	tests := []struct {
+		name           string
+		artifactJSON   string
+		version        string
+		srcMap         string
+		linkReferences []string
+		immutables     []CodeRange
+	}{
+		{"hardhat", testHardhatArtifact, "", "", []string{"contracts/Lib.sol:Lib"}, nil},
+		{"truffle", testTruffleArtifact, "0.8.19+commit.7dd6d404.Emscripten.clang", "1:2:0:-", nil, nil},
+		{"foundry", testFoundryArtifact, "0.8.19+commit.7dd6d404", "1:2:0:-", []string{"src/Lib.sol:Lib"}, []CodeRange{{10, 32}, {50, 32}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loaded, err := parseArtifact([]byte(test.artifactJSON))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := loaded.Contract
+			// Bytecode without a 0x prefix, as Foundry writes it, is prefixed
+			if loaded.ContractName != "Store" || c.Code != "0x6080" || c.RuntimeCode != "0x6001" {
+				t.Errorf("unexpected contract %s %s %s", loaded.ContractName, c.Code, c.RuntimeCode)
+			}
+			if c.Info.CompilerVersion != test.version || c.Info.SrcMap != test.srcMap || c.Info.Language != "Solidity" {
+				t.Errorf("unexpected contract info %+v", c.Info)
+			}
+			if !reflect.DeepEqual(loaded.LinkReferences, test.linkReferences) || !reflect.DeepEqual(loaded.Immutables, test.immutables) {
+				t.Errorf("unexpected link references %v, immutables %v", loaded.LinkReferences, loaded.Immutables)
+			}
+		})
+	}
+}
+
+func TestParseArtifactErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		artifactJSON string
+		err          string
+	}{
+		{"not json", `abi`, "unable to parse"},
+		{"no bytecode", `{"abi": []}`, "artifact does not contain 'abi' and 'bytecode'"},
+		{"no abi", `{"bytecode": "0x6080"}`, "artifact does not contain 'abi' and 'bytecode'"},
+		{"unknown format", `{"abi": [], "bytecode": "0x6080"}`, "unrecognized artifact format"},
+		{"bad foundry bytecode", `{"abi": [], "bytecode": {"object": 1}}`, "invalid bytecode"},
+		{"bad truffle bytecode", `{"contractName": "Store", "abi": [], "bytecode": ["0x6080"]}`, "invalid bytecode"},
+		{"interface", `{"_format": "hh-sol-artifact-1", "abi": [], "bytecode": "0x"}`, "artifact contains no bytecode (abstract contract or interface?)"},
+	}
+	for _, test := range tests {
+		if _, err := parseArtifact([]byte(test.artifactJSON)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing '%s', got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestLoadArtifact(t *testing.T) {
+	dir := t.TempDir()
+	artifactFile := writeTestFile(t, dir, "Store.json", testFoundryArtifact, 0600)
+	c, err := LoadArtifact(artifactFile, "Store", "set", []interface{}{"5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "Store" || c.Method.Sig != "set(uint256)" || len(c.PackedCall) != 4+32 || len(c.Immutables) != 2 {
+		t.Errorf("unexpected contract %+v", c)
+	}
+	if !reflect.DeepEqual(c.LinkReferences, []string{"src/Lib.sol:Lib"}) {
+		t.Errorf("unexpected link references %v", c.LinkReferences)
+	}
+
+	tests := []struct {
+		name         string
+		artifactFile string
+		contractName string
+		err          string
+	}{
+		{"other contract", artifactFile, "Token", "artifact " + artifactFile + " contains contract Store, not Token"},
+		{"missing", dir + "/missing.json", "", "unable to read artifact"},
+		{"invalid", writeTestFile(t, dir, "invalid.json", `{}`, 0600), "", "failed to load artifact"},
+	}
+	for _, test := range tests {
+		if _, err := LoadArtifact(test.artifactFile, test.contractName, "", nil); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing '%s', got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestLoadABI(t *testing.T) {
+	dir := t.TempDir()
+	for name, abiJSON := range map[string]string{
+		"array":    testArtifactABI,
+		"artifact": testHardhatArtifact,
+	} {
+		abiFile := writeTestFile(t, dir, name+".json", abiJSON, 0600)
+		c, err := LoadABI(abiFile, "set", []interface{}{"5"})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		// There is no bytecode, just the ABI and the packed call
+		if c.Method.Sig != "set(uint256)" || len(c.PackedCall) != 4+32 || c.Compiled != "" {
+			t.Errorf("%s: unexpected contract %+v", name, c)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		abiFile string
+		err     string
+	}{
+		{"missing", dir + "/missing.json", "unable to read ABI"},
+		{"invalid", writeTestFile(t, dir, "invalid.json", `[`, 0600), "unable to parse ABI"},
+		{"no abi", writeTestFile(t, dir, "object.json", `{"bytecode": "0x6080"}`, 0600), "must contain a JSON array, or an object with an 'abi' array"},
+		{"bad abi", writeTestFile(t, dir, "bad.json", `[{"type": "function", "name": "set", "inputs": [{"type": "unknown"}]}]`, 0600), "parsing ABI"},
+	}
+	for _, test := range tests {
+		if _, err := LoadABI(test.abiFile, "", nil); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing '%s', got %v", test.name, test.err, err)
+		}
+	}
+	abiFile := writeTestFile(t, dir, "abi.json", testArtifactABI, 0600)
+	if _, err := LoadABI(abiFile, "get", nil); err == nil || !strings.Contains(err.Error(), "method 'get' not found") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
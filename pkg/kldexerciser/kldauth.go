@@ -0,0 +1,212 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const redacted = "xxxxx"
+
+// redactURL removes the credentials from a URL so it can be logged: the user info,
+// and the values of any query parameters (which can hold API keys)
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query.Set(key, redacted)
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// redactError redacts the URL in the errors from the HTTP client, which only removes the password
+func redactError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		urlErr.URL = redactURL(urlErr.URL)
+	}
+	return err
+}
+
+// parseHeaders parses custom headers in the 'Name: value' form
+func parseHeaders(headers []string) (http.Header, error) {
+	parsed := make(http.Header)
+	for i, header := range headers {
+		idx := strings.Index(header, ":")
+		if idx <= 0 {
+			// The header is not logged, as it is likely to contain a credential
+			return nil, fmt.Errorf("invalid header %d (expected 'Name: value')", i+1)
+		}
+		parsed.Add(strings.TrimSpace(header[:idx]), strings.TrimSpace(header[idx+1:]))
+	}
+	return parsed, nil
+}
+
+// authorizer adds the custom headers and bearer token to the requests to the nodes. Tokens
+// from a file or command are re-read after the refresh period, for short lived tokens
+type authorizer struct {
+	headers      http.Header
+	tokenFile    string
+	tokenEnv     string
+	tokenCommand string
+	refresh      time.Duration
+	timeout      time.Duration
+	mux          sync.Mutex
+	token        string
+	fetched      time.Time
+}
+
+// getAuthorizer creates the authorizer on first use, checking the token can be read.
+// It is nil if no headers or tokens are configured
+func (e *Exerciser) getAuthorizer() (*authorizer, error) {
+	if e.authorizer != nil || (len(e.Headers) == 0 && e.TokenFile == "" && e.TokenEnv == "" && e.TokenCommand == "") {
+		return e.authorizer, nil
+	}
+	sources := 0
+	for _, source := range []string{e.TokenFile, e.TokenEnv, e.TokenCommand} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("only one of a token file, token environment variable or token command can be specified")
+	}
+	headers, err := parseHeaders(e.Headers)
+	if err != nil {
+		return nil, err
+	}
+	a := &authorizer{
+		headers:      headers,
+		tokenFile:    e.TokenFile,
+		tokenEnv:     e.TokenEnv,
+		tokenCommand: e.TokenCommand,
+		refresh:      time.Duration(e.TokenRefresh) * time.Second,
+		timeout:      time.Duration(e.RPCTimeout) * time.Second,
+	}
+	if _, err = a.bearerToken(); err != nil {
+		return nil, err
+	}
+	e.authorizer = a
+	return a, nil
+}
+
+// bearerToken returns the current token, reading it again once the refresh period has passed
+func (a *authorizer) bearerToken() (string, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if a.token != "" && (a.tokenEnv != "" || a.refresh <= 0 || time.Since(a.fetched) < a.refresh) {
+		return a.token, nil
+	}
+	var token []byte
+	var err error
+	var source string
+	switch {
+	case a.tokenFile != "":
+		source = "token file " + a.tokenFile
+		if token, err = ioutil.ReadFile(a.tokenFile); err != nil {
+			return "", fmt.Errorf("unable to read token file %s: %s", a.tokenFile, err)
+		}
+	case a.tokenEnv != "":
+		source = "environment variable " + a.tokenEnv
+		token = []byte(os.Getenv(a.tokenEnv))
+	case a.tokenCommand != "":
+		source = "token command"
+		if token, err = a.runTokenCommand(); err != nil {
+			return "", err
+		}
+	default:
+		return "", nil
+	}
+	if a.token = string(bytes.TrimSpace(token)); a.token == "" {
+		return "", fmt.Errorf("empty bearer token from %s", source)
+	}
+	a.fetched = time.Now()
+	return a.token, nil
+}
+
+// refreshes is true when the token is read again after the refresh period
+func (a *authorizer) refreshes() bool {
+	return (a.tokenFile != "" || a.tokenCommand != "") && a.refresh > 0
+}
+
+// runTokenCommand runs the command with the shell, and returns the token it prints
+func (a *authorizer) runTokenCommand() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", a.tokenCommand)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", a.tokenCommand)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	log.Debug("Running token command")
+	token, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("token command failed [%s]: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return token, nil
+}
+
+// apply sets the custom headers and bearer token on a request
+func (a *authorizer) apply(header http.Header) error {
+	for name, values := range a.headers {
+		header[name] = values
+	}
+	token, err := a.bearerToken()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// authTransport authorizes each HTTP request, so refreshed tokens are used as soon as they are read
+type authTransport struct {
+	base       http.RoundTripper
+	authorizer *authorizer
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authReq := req.Clone(req.Context())
+	if err := t.authorizer.apply(authReq.Header); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(authReq)
+}
@@ -0,0 +1,159 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cacheDir returns the configured cache directory, or the default in the user cache directory
+func (o *CompilerOptions) cacheDir() string {
+	if o.CacheDir != "" {
+		return o.CacheDir
+	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(userCacheDir, "kaleido-go", "solc")
+}
+
+// compileCacheKey hashes the solc version, compiler settings, main source, and the
+// base and include paths, which decide the files the imports resolve to. The paths
+// are absolute, so the key does not depend on how the source file was specified.
+// The imported sources are only known after compilation, so are stored with
+// their hashes in the cache entry and checked when it is loaded
+func (o *CompilerOptions) compileCacheKey(solcVer *SolcVersion, input *solcInput) string {
+	inputJSON, _ := json.Marshal(input)
+	h := sha256.New()
+	h.Write([]byte(solcVer.Version + "\n"))
+	h.Write([]byte(absPath(o.BasePath) + "\n"))
+	for _, includePath := range o.IncludePaths {
+		h.Write([]byte(absPath(includePath) + "\n"))
+	}
+	h.Write(inputJSON)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func absPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// resolveSourceFile finds the file on disk for a source unit name, using the
+// same base and include paths as solc
+func (o *CompilerOptions) resolveSourceFile(sourceName string) string {
+	candidates := []string{filepath.Join(o.BasePath, sourceName)}
+	for _, includePath := range o.IncludePaths {
+		candidates = append(candidates, filepath.Join(includePath, sourceName))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func hashFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// loadCachedBuild returns the cached build, as long as none of the sources have changed
+func (o *CompilerOptions) loadCachedBuild(cacheKey string) *solidityBuild {
+	dir := o.cacheDir()
+	if o.NoCache || dir == "" {
+		return nil
+	}
+	cacheFile := filepath.Join(dir, cacheKey+".json")
+	buildJSON, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		return nil
+	}
+	var build solidityBuild
+	if err := json.Unmarshal(buildJSON, &build); err != nil {
+		log.Debugf("Ignoring invalid compilation cache entry %s: %s", cacheFile, err)
+		return nil
+	}
+	for sourceName, expectedHash := range build.Sources {
+		if expectedHash == "" {
+			continue
+		}
+		path := o.resolveSourceFile(sourceName)
+		if path == "" {
+			return nil
+		}
+		if hash, err := hashFile(path); err != nil || hash != expectedHash {
+			log.Debugf("Source %s has changed since compilation cache entry %s", sourceName, cacheFile)
+			return nil
+		}
+	}
+	log.Debugf("Using compilation cache entry %s", cacheFile)
+	// Report the warnings again, so they are not hidden by the cache
+	for _, msg := range build.Warnings {
+		log.Warnf("solc: %s", msg.String())
+	}
+	return &build
+}
+
+// storeCachedBuild writes the build to the cache, with the hashes of the imported
+// sources. The main source is part of the key, so is not hashed separately
+func (o *CompilerOptions) storeCachedBuild(cacheKey, mainSource string, build *solidityBuild) {
+	dir := o.cacheDir()
+	if dir == "" {
+		return
+	}
+	for sourceName := range build.Sources {
+		if sourceName == mainSource {
+			continue
+		}
+		path := o.resolveSourceFile(sourceName)
+		if path == "" {
+			log.Debugf("Not caching compilation, as source %s could not be found", sourceName)
+			return
+		}
+		hash, err := hashFile(path)
+		if err != nil {
+			return
+		}
+		build.Sources[sourceName] = hash
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Warnf("Unable to create compilation cache directory %s: %s", dir, err)
+		return
+	}
+	buildJSON, _ := json.Marshal(build)
+	cacheFile := filepath.Join(dir, cacheKey+".json")
+	if err := ioutil.WriteFile(cacheFile, buildJSON, 0600); err != nil {
+		log.Warnf("Unable to write compilation cache entry %s: %s", cacheFile, err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/compiler"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func chdir(t *testing.T, dir string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+func TestWithSourceBasePath(t *testing.T) {
+	opts := &CompilerOptions{IncludePaths: []string{"lib"}}
+	solc := &SolcVersion{Version: "0.8.19"}
+	withBase := opts.withSourceBasePath(solc, filepath.Join("contracts", "Token.sol"))
+	if withBase.BasePath != "contracts" || strings.Join(withBase.IncludePaths, ",") != ".,lib" {
+		t.Errorf("unexpected paths: %s %s", withBase.BasePath, withBase.IncludePaths)
+	}
+	if opts.BasePath != "" || len(opts.IncludePaths) != 1 {
+		t.Errorf("options modified: %+v", opts)
+	}
+	if unitName := withBase.sourceUnitName(filepath.Join("contracts", "Token.sol")); unitName != "Token.sol" {
+		t.Errorf("unexpected unit name %s", unitName)
+	}
+
+	// Older solc versions have no include paths, so keep resolving from the working directory
+	if old := opts.withSourceBasePath(&SolcVersion{Version: "0.8.7"}, "contracts/Token.sol"); old != opts {
+		t.Errorf("base path set for solc 0.8.7: %+v", old)
+	}
+	// An explicit base path is used as-is
+	explicit := &CompilerOptions{BasePath: "."}
+	if explicit.withSourceBasePath(solc, "contracts/Token.sol") != explicit {
+		t.Errorf("explicit base path replaced")
+	}
+}
+
+func TestCompileCacheKeyPaths(t *testing.T) {
+	projectDir := t.TempDir()
+	contractsDir := filepath.Join(projectDir, "contracts")
+	if err := os.Mkdir(contractsDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, contractsDir, "Token.sol", "contract Token {}", 0600)
+	solc := &SolcVersion{Version: "0.8.19"}
+
+	cacheKey := func(solidityFile string, includePaths ...string) string {
+		opts := (&CompilerOptions{IncludePaths: includePaths}).withSourceBasePath(solc, solidityFile)
+		input := newSolcInput(opts.sourceUnitName(solidityFile), []byte("contract Token {}"), opts)
+		return opts.compileCacheKey(solc, input)
+	}
+	chdir(t, projectDir)
+	fromProject := cacheKey(filepath.Join("contracts", "Token.sol"))
+	if other := cacheKey(filepath.Join("..", filepath.Base(projectDir), "contracts", "Token.sol")); other != fromProject {
+		t.Errorf("cache key depends on the path to the source: %s != %s", other, fromProject)
+	}
+	if other := cacheKey(filepath.Join(contractsDir, "Token.sol")); other != fromProject {
+		t.Errorf("cache key depends on the path to the source: %s != %s", other, fromProject)
+	}
+	// The include paths can change the file an import resolves to
+	if other := cacheKey(filepath.Join("contracts", "Token.sol"), "node_modules"); other == fromProject {
+		t.Errorf("cache key does not depend on the include paths")
+	}
+	if cacheKey(filepath.Join("contracts", "Token.sol"), "node_modules") != cacheKey(filepath.Join("contracts", "Token.sol"), filepath.Join(projectDir, "node_modules")) {
+		t.Errorf("cache key depends on how the include path was specified")
+	}
+	// The working directory is an include path by default
+	chdir(t, contractsDir)
+	if other := cacheKey("Token.sol"); other == fromProject {
+		t.Errorf("cache key does not depend on the working directory include path")
+	}
+}
+
+func TestCachedBuildRoundTrip(t *testing.T) {
+	srcDir := t.TempDir()
+	libFile := writeTestFile(t, srcDir, "Lib.sol", "library Lib {}", 0600)
+	opts := &CompilerOptions{BasePath: srcDir, CacheDir: t.TempDir()}
+	build := &solidityBuild{
+		SolcVersion: "0.8.19",
+		Sources:     map[string]string{"Token.sol": "", "Lib.sol": ""},
+		Contracts:   map[string]*compiler.Contract{"Token.sol:Token": {Code: "0x6080"}},
+		Warnings:    []CompilerMessage{{Severity: "warning", Type: "Warning", Message: "Unused local variable."}},
+	}
+	opts.storeCachedBuild("key1", "Token.sol", build)
+
+	logger, hook := logtest.NewNullLogger()
+	log.StandardLogger().ReplaceHooks(logger.Hooks)
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	cached := opts.loadCachedBuild("key1")
+	if cached == nil || cached.Contracts["Token.sol:Token"].Code != "0x6080" {
+		t.Fatalf("cached build not loaded: %+v", cached)
+	}
+	warned := false
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == log.WarnLevel && strings.Contains(entry.Message, "Unused local variable.") {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Errorf("cached warning not logged")
+	}
+
+	// Changing an import invalidates the entry
+	writeTestFile(t, srcDir, filepath.Base(libFile), "library Lib { }", 0600)
+	if opts.loadCachedBuild("key1") != nil {
+		t.Errorf("cached build used after an import changed")
+	}
+	opts.NoCache = true
+	if opts.loadCachedBuild("key1") != nil {
+		t.Errorf("cached build used with NoCache")
+	}
+}
@@ -18,63 +18,158 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"math/big"
+	"io/ioutil"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/compiler"
 	log "github.com/sirupsen/logrus"
 )
 
 // CompiledSolidity wraps solc compilation of solidity and ABI generation
 type CompiledSolidity struct {
-	Compiled     string
-	ContractInfo compiler.ContractInfo
-	PackedCall   []byte
+	Name            string
+	Compiled        string
+	ContractInfo    compiler.ContractInfo
+	ABI             abi.ABI
+	Method          *abi.Method
+	PackedCall      []byte
+	PackedConstruct []byte
+	Warnings        []CompilerMessage
+	Libraries       map[string]string
+	LinkReferences  []string
+	SourceFiles     []string
+	RuntimeCode     string
+	Immutables      []CodeRange // nil when not known, for artifacts that do not record them
 }
 
-// GenerateTypedArgs parses string arguments into a range of types to pass to the ABI call
-func GenerateTypedArgs(abi abi.ABI, methodName string, strargs []string) ([]interface{}, error) {
+// abiOverload is a method or event in the ABI, keyed by the name go-ethereum
+// assigns to it, which is de-duplicated for overloads (such as 'transfer0')
+type abiOverload struct {
+	key     string
+	rawName string
+	sig     string
+}
 
-	method, exist := abi.Methods[methodName]
-	if !exist {
-		return nil, fmt.Errorf("method '%s' not found", methodName)
+// resolveOverload finds the method or event with the name or full signature supplied,
+// returning its key in the ABI. The signature is required to select between overloads
+func resolveOverload(kind, name string, overloads []abiOverload) (string, error) {
+	name = strings.Join(strings.Fields(name), "")
+	nameOnly := name
+	if idx := strings.Index(name, "("); idx >= 0 {
+		nameOnly = name[:idx]
 	}
 
-	log.Debug("Parsing args for method: ", method)
-	var typedArgs []interface{}
-	for idx, inputArg := range method.Inputs {
-		if idx >= len(strargs) {
-			return nil, fmt.Errorf("method requires %d args: %s", len(method.Inputs), method)
-		}
-		strval := strargs[idx]
-		switch inputArg.Type.String() {
-		case "string":
-			typedArgs = append(typedArgs, strval)
-		case "int256", "uint256":
-			bigInt := big.NewInt(0)
-			if _, ok := bigInt.SetString(strval, 10); !ok {
-				return nil, fmt.Errorf("could not convert '%s' to %s", strval, inputArg.Type)
-			}
-			typedArgs = append(typedArgs, bigInt)
-		case "bool":
-			typedArgs = append(typedArgs, strings.ToLower(strval) == "true")
-		case "address":
-			if !common.IsHexAddress(strval) {
-				return nil, fmt.Errorf("invalid hex address for arg %d: %s", idx, strval)
+	var candidates []string
+	matched := ""
+	for _, o := range overloads {
+		if o.rawName != nameOnly {
+			continue
+		}
+		candidates = append(candidates, o.sig)
+		if o.sig == name || nameOnly == name {
+			matched = o.key
+		}
+	}
+	switch {
+	case len(candidates) == 0:
+		// Allow the de-duplicated name
+		for _, o := range overloads {
+			if o.key == name {
+				return o.key, nil
 			}
-			typedArgs = append(typedArgs, common.HexToAddress(strval))
-		default:
-			return nil, fmt.Errorf("no string parsing configured yet for type %s", inputArg.Type)
 		}
+		return "", fmt.Errorf("%s '%s' not found", kind, name)
+	case matched == "":
+		return "", fmt.Errorf("%s '%s' not found. Signatures: %s", kind, name, strings.Join(candidates, ", "))
+	case nameOnly == name && len(candidates) > 1:
+		return "", fmt.Errorf("%s '%s' is overloaded, please specify the signature: %s", kind, name, strings.Join(candidates, ", "))
+	}
+	return matched, nil
+}
+
+// FindMethod finds a method by name, or by its full signature such as 'transfer(address,uint256)'
+// which is required to select between overloaded methods
+func FindMethod(contractABI abi.ABI, methodName string) (*abi.Method, error) {
+	names := sortedMethodNames(contractABI)
+	overloads := make([]abiOverload, len(names))
+	for i, name := range names {
+		method := contractABI.Methods[name]
+		overloads[i] = abiOverload{key: name, rawName: method.RawName, sig: method.Sig}
+	}
+	key, err := resolveOverload("method", methodName, overloads)
+	if err != nil {
+		return nil, err
+	}
+	method := contractABI.Methods[key]
+	return &method, nil
+}
+
+func sortedMethodNames(contractABI abi.ABI) []string {
+	names := make([]string, 0, len(contractABI.Methods))
+	for name := range contractABI.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GenerateTypedArgs parses string or JSON arguments into a range of types to pass to the ABI call
+func GenerateTypedArgs(abi abi.ABI, methodName string, args []interface{}) ([]interface{}, error) {
+	method, err := FindMethod(abi, methodName)
+	if err != nil {
+		return nil, err
+	}
+	return methodTypedArgs(method, args)
+}
+
+// methodTypedArgs checks the number of arguments for the method, and parses them into its input types
+func methodTypedArgs(method *abi.Method, args []interface{}) ([]interface{}, error) {
+	log.Debug("Parsing args for method: ", method)
+	if len(args) != len(method.Inputs) {
+		return nil, fmt.Errorf("method requires %d args (%d supplied): %s", len(method.Inputs), len(args), method)
 	}
+	return generateTypedArgs(method.Inputs, args)
+}
 
+// GenerateConstructorArgs parses string or JSON arguments for the constructor of the contract
+func GenerateConstructorArgs(abi abi.ABI, args []interface{}) ([]interface{}, error) {
+	log.Debug("Parsing args for constructor: ", abi.Constructor)
+	if len(args) != len(abi.Constructor.Inputs) {
+		return nil, fmt.Errorf("constructor requires %d args (%d supplied): %s", len(abi.Constructor.Inputs), len(args), abi.Constructor)
+	}
+	return generateTypedArgs(abi.Constructor.Inputs, args)
+}
+
+func generateTypedArgs(inputs abi.Arguments, args []interface{}) ([]interface{}, error) {
+	var typedArgs []interface{}
+	for idx, inputArg := range inputs {
+		typedArg, err := ConvertArg(inputArg.Type, args[idx])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for arg %d (%s): %s", idx, inputArg.Name, err)
+		}
+		typedArgs = append(typedArgs, typedArg)
+	}
 	return typedArgs, nil
+}
 
+// PackConstructor packs the constructor arguments to append to the bytecode on deployment
+func (c *CompiledSolidity) PackConstructor(args []interface{}) error {
+	typedArgs, err := GenerateConstructorArgs(c.ABI, args)
+	if err != nil {
+		return err
+	}
+	packedConstruct, err := c.ABI.Pack("", typedArgs...)
+	if err != nil {
+		return fmt.Errorf("packing constructor arguments %v: %s", args, err)
+	}
+	c.PackedConstruct = packedConstruct
+	return nil
 }
 
 type SolcVersion struct {
@@ -85,76 +180,453 @@ type SolcVersion struct {
 var solcVerExtractor = regexp.MustCompile(`\d+\.\d+\.\d+`)
 
 func getSolcVersion(solcPath string) (*SolcVersion, error) {
+	return getCompilerVersion("solc", solcPath)
+}
+
+// getCompilerVersion runs the compiler to find its version
+func getCompilerVersion(compilerName, compilerPath string) (*SolcVersion, error) {
 
 	cmdOutput := new(bytes.Buffer)
-	cmd := exec.Command(solcPath, "--version")
+	cmd := exec.Command(compilerPath, "--version")
 	cmd.Stdout = cmdOutput
 
 	err := cmd.Run()
 	if err != nil {
-		return nil, fmt.Errorf("failed to invoke solc binary '%s' to check version: %s", solcPath, err)
+		return nil, fmt.Errorf("failed to invoke %s binary '%s' to check version: %s", compilerName, compilerPath, err)
 	}
 
 	ver := solcVerExtractor.FindString(cmdOutput.String())
 	if ver == "" {
-		return nil, fmt.Errorf("failed to extract version from solc '%s' output: %s", solcPath, cmdOutput.String())
+		return nil, fmt.Errorf("failed to extract version from %s '%s' output: %s", compilerName, compilerPath, cmdOutput.String())
 	}
 
 	return &SolcVersion{
-		Path:    solcPath,
+		Path:    compilerPath,
 		Version: ver,
 	}, nil
 
 }
 
-// CompileContract uses solc to compile the Solidity source and
-func CompileContract(solidityFile, evmVersion, contractName, method string, args []string) (*CompiledSolidity, error) {
-	var c CompiledSolidity
+// CompilerOptions configures how solc is found and invoked
+type CompilerOptions struct {
+	SolcPath        string
+	SolcReleasesDir string
+	EVMVersion      string
+	Optimize        bool
+	OptimizerRuns   int
+	ViaIR           bool
+	Remappings      []string
+	BasePath        string
+	IncludePaths    []string
+	CacheDir        string
+	NoCache         bool
+	VyperPath       string
+}
 
-	solcVer, err := getSolcVersion("solc")
-	if err != nil {
-		return nil, fmt.Errorf("failed to find solidity version: %s", err)
+// solcEVMVersion is the EVM version to compile Solidity for
+func (o *CompilerOptions) solcEVMVersion() string {
+	if o.EVMVersion == "" {
+		return defaultSolcEVMVersion
+	}
+	return o.EVMVersion
+}
+
+// findSolc selects the solc binary from the releases directory that matches the pragmas in
+// the source, or uses the configured solc binary. The command line rejects having both, but
+// the releases directory takes precedence if it is set
+func (o *CompilerOptions) findSolc(solidityFile string) (*SolcVersion, error) {
+	if o.SolcReleasesDir != "" {
+		return SelectSolc(solidityFile, o.SolcReleasesDir)
+	}
+	solcPath := o.SolcPath
+	if solcPath == "" {
+		solcPath = "solc"
+	}
+	return getSolcVersion(solcPath)
+}
+
+// defaultSolcEVMVersion is the EVM version for solc when none is specified
+const defaultSolcEVMVersion = "byzantium"
+
+// includePathsSolc is the first solc version with --include-path
+var includePathsSolc = semver{0, 8, 8}
+
+// withSourceBasePath defaults the base path to the directory of the source file, so the
+// source unit names, and the compilation cache key, do not depend on the directory we are
+// run from. The working directory stays an include path, for imports and remappings
+// relative to it. Older solc versions without include paths resolve from the working directory
+func (o *CompilerOptions) withSourceBasePath(solcVer *SolcVersion, solidityFile string) *CompilerOptions {
+	if o.BasePath != "" {
+		return o
+	}
+	if v, err := parseSemver(solcVer.Version); err != nil || v.cmp(includePathsSolc) < 0 {
+		return o
+	}
+	withBasePath := *o
+	withBasePath.BasePath = filepath.Dir(solidityFile)
+	withBasePath.IncludePaths = append([]string{"."}, o.IncludePaths...)
+	return &withBasePath
+}
+
+// sourceUnitName is the name solc uses for the file, which is relative to the
+// base path if one is set
+func (o *CompilerOptions) sourceUnitName(solidityFile string) string {
+	if o.BasePath != "" {
+		if rel, err := filepath.Rel(o.BasePath, solidityFile); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(solidityFile))
+}
+
+type solcSource struct {
+	Content string `json:"content"`
+}
+
+type solcOptimizer struct {
+	Enabled bool `json:"enabled"`
+	Runs    int  `json:"runs"`
+}
+
+type solcSettings struct {
+	Remappings      []string                       `json:"remappings,omitempty"`
+	Optimizer       solcOptimizer                  `json:"optimizer"`
+	EVMVersion      string                         `json:"evmVersion,omitempty"`
+	ViaIR           bool                           `json:"viaIR,omitempty"`
+	OutputSelection map[string]map[string][]string `json:"outputSelection"`
+}
+
+// solcInput is the solc --standard-json input
+type solcInput struct {
+	Language string                `json:"language"`
+	Sources  map[string]solcSource `json:"sources"`
+	Settings solcSettings          `json:"settings"`
+}
+
+// CodeRange is a range of bytes in the bytecode, such as an immutable variable
+type CodeRange struct {
+	Start  int `json:"start"`
+	Length int `json:"length"`
+}
+
+type solcBytecode struct {
+	Object              string                 `json:"object"`
+	SourceMap           string                 `json:"sourceMap"`
+	ImmutableReferences map[string][]CodeRange `json:"immutableReferences,omitempty"`
+}
+
+type solcContract struct {
+	ABI      interface{} `json:"abi"`
+	Metadata string      `json:"metadata"`
+	UserDoc  interface{} `json:"userdoc"`
+	DevDoc   interface{} `json:"devdoc"`
+	EVM      struct {
+		Bytecode          solcBytecode      `json:"bytecode"`
+		DeployedBytecode  solcBytecode      `json:"deployedBytecode"`
+		MethodIdentifiers map[string]string `json:"methodIdentifiers"`
+	} `json:"evm"`
+}
+
+// solcOutput is the solc --standard-json output
+type solcOutput struct {
+	Errors  []CompilerMessage `json:"errors"`
+	Sources map[string]struct {
+		ID int `json:"id"`
+	} `json:"sources"`
+	Contracts map[string]map[string]solcContract `json:"contracts"`
+}
+
+// CompilerMessage is an error, warning or info message reported by solc
+type CompilerMessage struct {
+	Severity         string `json:"severity"`
+	Type             string `json:"type"`
+	Component        string `json:"component"`
+	ErrorCode        string `json:"errorCode,omitempty"`
+	Message          string `json:"message"`
+	FormattedMessage string `json:"formattedMessage,omitempty"`
+	SourceLocation   *struct {
+		File  string `json:"file"`
+		Start int    `json:"start"`
+		End   int    `json:"end"`
+	} `json:"sourceLocation,omitempty"`
+}
+
+func (m *CompilerMessage) String() string {
+	location := ""
+	if m.SourceLocation != nil {
+		location = fmt.Sprintf("%s (offset %d-%d): ", m.SourceLocation.File, m.SourceLocation.Start, m.SourceLocation.End)
 	}
-	solcArgs := []string{
-		"--combined-json", "bin,bin-runtime,srcmap,srcmap-runtime,abi,userdoc,devdoc,metadata",
-		"--optimize",
-		"--evm-version", evmVersion,
-		"--allow-paths", ".",
-		solidityFile,
+	return fmt.Sprintf("%s%s: %s", location, m.Type, m.Message)
+}
+
+// CompilationError is returned when solc reports errors compiling the source
+type CompilationError struct {
+	Messages []CompilerMessage
+}
+
+func (e *CompilationError) Error() string {
+	errors := make([]string, len(e.Messages))
+	for i := range e.Messages {
+		errors[i] = e.Messages[i].String()
 	}
-	solOptionsString := strings.Join(append([]string{solcVer.Path}, solcArgs...), " ")
-	log.Debugf("Compiling: %s", solOptionsString)
+	return fmt.Sprintf("solc reported %d error(s):\n%s", len(errors), strings.Join(errors, "\n"))
+}
+
+// runSolc invokes solc with the standard JSON input, and parses the output
+func runSolc(solcVer *SolcVersion, opts *CompilerOptions, input *solcInput) (*solcOutput, error) {
+	solcArgs := []string{"--standard-json"}
+	allowPaths := []string{"."}
+	if opts.BasePath != "" {
+		solcArgs = append(solcArgs, "--base-path", opts.BasePath)
+		allowPaths = append(allowPaths, opts.BasePath)
+	}
+	for _, includePath := range opts.IncludePaths {
+		solcArgs = append(solcArgs, "--include-path", includePath)
+		allowPaths = append(allowPaths, includePath)
+	}
+	solcArgs = append(solcArgs, "--allow-paths", strings.Join(allowPaths, ","))
+
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("serializing solc input: %s", err)
+	}
+	log.Debugf("Compiling: %s %s", solcVer.Path, strings.Join(solcArgs, " "))
+	log.Debugf("Compiler settings: %s", inputJSON)
 	cmd := exec.Command(solcVer.Path, solcArgs...)
 
 	// Compile the solidity
 	var stderr, stdout bytes.Buffer
+	cmd.Stdin = bytes.NewReader(inputJSON)
 	cmd.Stderr = &stderr
 	cmd.Stdout = &stdout
 	if err := cmd.Run(); err != nil {
 		return nil, fmt.Errorf("failed to compile [%s]: %s", err, stderr.String())
 	}
 
-	compiled, err := compiler.ParseCombinedJSON(stdout.Bytes(), "", solcVer.Version, solcVer.Version, solOptionsString)
-	if err != nil {
+	var output solcOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
 		return nil, fmt.Errorf("failed to parse solc output: %s", err)
 	}
+	return &output, nil
+}
+
+// solidityBuild is the output of compiling a Solidity file with all its imports
+type solidityBuild struct {
+	SolcVersion string                        `json:"solcVersion"`
+	Sources     map[string]string             `json:"sources"`
+	SourceIDs   map[string]int                `json:"sourceIds,omitempty"`
+	Contracts   map[string]*compiler.Contract `json:"contracts"`
+	Immutables  map[string][]CodeRange        `json:"immutables,omitempty"`
+	Warnings    []CompilerMessage             `json:"warnings,omitempty"`
+}
+
+// newSolcInput builds the standard JSON input to compile the source
+func newSolcInput(unitName string, source []byte, opts *CompilerOptions) *solcInput {
+	return &solcInput{
+		Language: "Solidity",
+		Sources: map[string]solcSource{
+			unitName: {Content: string(source)},
+		},
+		Settings: solcSettings{
+			Remappings: opts.Remappings,
+			Optimizer: solcOptimizer{
+				Enabled: opts.Optimize,
+				Runs:    opts.OptimizerRuns,
+			},
+			EVMVersion: opts.solcEVMVersion(),
+			ViaIR:      opts.ViaIR,
+			OutputSelection: map[string]map[string][]string{
+				"*": {
+					"*": {
+						"abi", "metadata", "userdoc", "devdoc",
+						"evm.bytecode.object", "evm.bytecode.sourceMap",
+						"evm.deployedBytecode.object", "evm.deployedBytecode.sourceMap",
+						"evm.deployedBytecode.immutableReferences",
+						"evm.methodIdentifiers",
+					},
+				},
+			},
+		},
+	}
+}
+
+// compileSolidity runs solc, and builds the contracts from the output
+func compileSolidity(solcVer *SolcVersion, opts *CompilerOptions, input *solcInput) (*solidityBuild, error) {
+	output, err := runSolc(solcVer, opts, input)
+	if err != nil {
+		return nil, err
+	}
+
+	// Surface the errors and warnings from the compiler
+	build := &solidityBuild{
+		SolcVersion: solcVer.Version,
+		Sources:     make(map[string]string),
+		SourceIDs:   make(map[string]int),
+		Contracts:   make(map[string]*compiler.Contract),
+		Immutables:  make(map[string][]CodeRange),
+	}
+	var compileErrors []CompilerMessage
+	for _, msg := range output.Errors {
+		switch msg.Severity {
+		case "error":
+			compileErrors = append(compileErrors, msg)
+		case "warning":
+			build.Warnings = append(build.Warnings, msg)
+			log.Warnf("solc: %s", msg.String())
+		default:
+			log.Debugf("solc: %s", msg.String())
+		}
+	}
+	if len(compileErrors) > 0 {
+		return nil, &CompilationError{Messages: compileErrors}
+	}
+
+	settingsJSON, _ := json.Marshal(&input.Settings)
+	for sourceName, source := range output.Sources {
+		build.Sources[sourceName] = ""
+		build.SourceIDs[sourceName] = source.ID
+	}
+	for sourceName, contracts := range output.Contracts {
+		for name, info := range contracts {
+			for _, refs := range info.EVM.DeployedBytecode.ImmutableReferences {
+				build.Immutables[sourceName+":"+name] = append(build.Immutables[sourceName+":"+name], refs...)
+			}
+			build.Contracts[sourceName+":"+name] = &compiler.Contract{
+				Code:        prefixHex(info.EVM.Bytecode.Object),
+				RuntimeCode: prefixHex(info.EVM.DeployedBytecode.Object),
+				Hashes:      info.EVM.MethodIdentifiers,
+				Info: compiler.ContractInfo{
+					Language:        "Solidity",
+					LanguageVersion: solcVer.Version,
+					CompilerVersion: solcVer.Version,
+					CompilerOptions: string(settingsJSON),
+					SrcMap:          info.EVM.Bytecode.SourceMap,
+					SrcMapRuntime:   info.EVM.DeployedBytecode.SourceMap,
+					AbiDefinition:   info.ABI,
+					UserDoc:         info.UserDoc,
+					DeveloperDoc:    info.DevDoc,
+					Metadata:        info.Metadata,
+				},
+			}
+		}
+	}
+	return build, nil
+}
+
+// CompileContract uses solc to compile the Solidity source and
+func CompileContract(solidityFile string, opts *CompilerOptions, contractName, method string, args []interface{}) (*CompiledSolidity, error) {
+	if filepath.Ext(solidityFile) == ".vy" {
+		return compileVyper(solidityFile, opts, contractName, method, args)
+	}
+	solcVer, err := opts.findSolc(solidityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find solidity version: %s", err)
+	}
+	source, err := ioutil.ReadFile(solidityFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", solidityFile, err)
+	}
+
+	opts = opts.withSourceBasePath(solcVer, solidityFile)
+	unitName := opts.sourceUnitName(solidityFile)
+	input := newSolcInput(unitName, source, opts)
+	cacheKey := opts.compileCacheKey(solcVer, input)
+	build := opts.loadCachedBuild(cacheKey)
+	if build == nil {
+		if build, err = compileSolidity(solcVer, opts, input); err != nil {
+			return nil, err
+		}
+		opts.storeCachedBuild(cacheKey, unitName, build)
+	}
 
-	// Check we only have one conract and grab the code/info
-	var contract *compiler.Contract
+	compiled := make(map[string]*compiler.Contract)
+	for fullName, contract := range build.Contracts {
+		// Only deployable contracts in the main file are candidates, unless a name is specified
+		if contractName == "" && (!strings.HasPrefix(fullName, unitName+":") || contract.Code == "") {
+			continue
+		}
+		compiled[fullName] = contract
+	}
+	contract, err := selectContract(compiled, contractName, "Solidity file")
+	if err != nil {
+		return nil, err
+	}
+	contract.Info.Source = string(source)
+	c, err := newCompiledSolidity(contract, method, args)
+	if err != nil {
+		return nil, err
+	}
+	c.Warnings = build.Warnings
+	for fullName, candidate := range build.Contracts {
+		if candidate == contract {
+			c.Name = fullName
+			// solc reports the immutable references of every contract, so none is an empty list
+			c.Immutables = append([]CodeRange{}, build.Immutables[fullName]...)
+		}
+	}
+	// Any contract in the build could be a library we need to deploy and link
+	c.Libraries = make(map[string]string)
+	for fullName, contract := range build.Contracts {
+		if contract.Code != "" {
+			c.Libraries[fullName] = contract.Code
+		}
+	}
+	// The files by source ID, to resolve locations in the source maps
+	for sourceName, id := range build.SourceIDs {
+		for len(c.SourceFiles) <= id {
+			c.SourceFiles = append(c.SourceFiles, "")
+		}
+		if sourceName == unitName {
+			c.SourceFiles[id] = solidityFile
+		} else {
+			c.SourceFiles[id] = opts.resolveSourceFile(sourceName)
+		}
+	}
+	return c, nil
+}
+
+// selectContract checks we only have one contract, or the one requested exists
+func selectContract(compiled map[string]*compiler.Contract, contractName, source string) (*compiler.Contract, error) {
 	contractNames := reflect.ValueOf(compiled).MapKeys()
 	if contractName != "" {
-		if _, ok := compiled[contractName]; !ok {
-			return nil, fmt.Errorf("contract %s not found in Solidity file: %s", contractName, contractNames)
+		if contract, ok := compiled[contractName]; ok {
+			return contract, nil
+		}
+		// Allow the name without the source file prefix, as long as it is unique
+		var matched *compiler.Contract
+		for fullName, contract := range compiled {
+			if strings.HasSuffix(fullName, ":"+contractName) {
+				if matched != nil {
+					return nil, fmt.Errorf("contract %s is ambiguous in %s, please include the file: %s", contractName, source, contractNames)
+				}
+				matched = contract
+			}
 		}
-		contract = compiled[contractName]
+		if matched == nil {
+			return nil, fmt.Errorf("contract %s not found in %s: %s", contractName, source, contractNames)
+		}
+		return matched, nil
 	} else if len(contractNames) != 1 {
-		return nil, fmt.Errorf("more than one contract in Solidity file, please set one to call: %s", contractNames)
-	} else {
-		contractName = contractNames[0].String()
-		contract = compiled[contractName]
+		return nil, fmt.Errorf("more than one contract in %s, please set one to call: %s", source, contractNames)
 	}
+	return compiled[contractNames[0].String()], nil
+}
+
+// packMethodCall packs the method selector and arguments
+func packMethodCall(method *abi.Method, typedArgs []interface{}) ([]byte, error) {
+	packedArgs, err := method.Inputs.Pack(typedArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, method.ID...), packedArgs...), nil
+}
+
+// newCompiledSolidity grabs the code/info from the contract, and packs the call
+func newCompiledSolidity(contract *compiler.Contract, method string, args []interface{}) (*CompiledSolidity, error) {
+	var c CompiledSolidity
 	c.ContractInfo = contract.Info
 	c.Compiled = contract.Code
+	c.RuntimeCode = contract.RuntimeCode
 
 	// Pack the arguments for calling the contract
 	abiJSON, err := json.Marshal(contract.Info.AbiDefinition)
@@ -165,15 +637,34 @@ func CompileContract(solidityFile, evmVersion, contractName, method string, args
 	if err != nil {
 		return nil, fmt.Errorf("parsing ABI: %s", err)
 	}
-	typedArgs, err := GenerateTypedArgs(abi, method, args)
-	if err != nil {
+	c.ABI = abi
+	if method == "" {
+		// The methods are resolved separately, such as for a workload mix
+		return &c, nil
+	}
+	if c.Method, err = FindMethod(abi, method); err != nil {
 		return nil, err
 	}
-	packedCall, err := abi.Pack(method, typedArgs...)
-	if err != nil {
-		return nil, fmt.Errorf("packing arguments %s for call %s: %s", args, method, err)
+	if c.PackedCall, err = c.PackCall(args); err != nil {
+		return nil, err
 	}
-	c.PackedCall = packedCall
 
 	return &c, nil
 }
+
+// PackCall parses the arguments for the method, and packs them into the call data
+func (c *CompiledSolidity) PackCall(args []interface{}) ([]byte, error) {
+	return packCall(c.Method, args)
+}
+
+func packCall(method *abi.Method, args []interface{}) ([]byte, error) {
+	typedArgs, err := methodTypedArgs(method, args)
+	if err != nil {
+		return nil, err
+	}
+	packedCall, err := packMethodCall(method, typedArgs)
+	if err != nil {
+		return nil, fmt.Errorf("packing arguments %v for call %s: %s", args, method.Sig, err)
+	}
+	return packedCall, nil
+}
@@ -0,0 +1,343 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const testOverloadsABI = `[
+	{"type": "function", "name": "transfer", "stateMutability": "nonpayable", "inputs": [
+		{"name": "to", "type": "address"}, {"name": "value", "type": "uint256"}
+	], "outputs": []},
+	{"type": "function", "name": "transfer", "stateMutability": "nonpayable", "inputs": [
+		{"name": "to", "type": "address"}
+	], "outputs": []},
+	{"type": "function", "name": "get", "stateMutability": "view", "inputs": [], "outputs": [
+		{"name": "", "type": "uint256"}
+	]}
+]`
+
+func testOverloadsContractABI(t *testing.T) abi.ABI {
+	contractABI, err := abi.JSON(strings.NewReader(testOverloadsABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contractABI
+}
+
+func TestFindMethod(t *testing.T) {
+	contractABI := testOverloadsContractABI(t)
+	tests := []struct {
+		name string
+		sig  string
+		err  string
+	}{
+		{"get", "get()", ""},
+		{"get()", "get()", ""},
+		{"transfer(address,uint256)", "transfer(address,uint256)", ""},
+		{"transfer(address)", "transfer(address)", ""},
+		{" transfer( address, uint256 ) ", "transfer(address,uint256)", ""},
+		// The de-duplicated name go-ethereum assigns to the second overload
+		{"transfer0", "transfer(address)", ""},
+		// The signatures are listed in the order of the de-duplicated names: transfer, transfer0
+		{"transfer", "", "method 'transfer' is overloaded, please specify the signature: transfer(address,uint256), transfer(address)"},
+		{"transfer(uint256)", "", "method 'transfer(uint256)' not found. Signatures: transfer(address,uint256), transfer(address)"},
+		{"get(uint256)", "", "method 'get(uint256)' not found. Signatures: get()"},
+		{"set", "", "method 'set' not found"},
+		{"", "", "method '' not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method, err := FindMethod(contractABI, test.name)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error '%s', got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if method.Sig != test.sig {
+				t.Errorf("expected %s, got %s", test.sig, method.Sig)
+			}
+		})
+	}
+}
+
+func TestGenerateTypedArgs(t *testing.T) {
+	contractABI := testOverloadsContractABI(t)
+	typedArgs, err := GenerateTypedArgs(contractABI, "transfer(address,uint256)", []interface{}{"0x0102030405060708090a0b0c0d0e0f1011121314", "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typedArgs[0].(common.Address) != common.HexToAddress("0x0102030405060708090a0b0c0d0e0f1011121314") || typedArgs[1].(*big.Int).Int64() != 10 {
+		t.Errorf("unexpected args %v", typedArgs)
+	}
+	tests := []struct {
+		method string
+		args   []interface{}
+		err    string
+	}{
+		{"transfer", nil, "method 'transfer' is overloaded"},
+		{"transfer(address)", []interface{}{"0x01", "10"}, "method requires 1 args (2 supplied)"},
+		{"transfer(address,uint256)", []interface{}{"0x0102030405060708090a0b0c0d0e0f1011121314", "ten"}, "invalid value for arg 1 (value)"},
+	}
+	for _, test := range tests {
+		if _, err := GenerateTypedArgs(contractABI, test.method, test.args); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing '%s', got %v", test.method, test.err, err)
+		}
+	}
+}
+
+func TestPackCallSelectsOverload(t *testing.T) {
+	contractABI := testOverloadsContractABI(t)
+	to := "0x0102030405060708090a0b0c0d0e0f1011121314"
+	for sig, args := range map[string][]interface{}{
+		"transfer(address)":         {to},
+		"transfer(address,uint256)": {to, "10"},
+	} {
+		method, err := FindMethod(contractABI, sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packed, err := packCall(method, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The selector is that of the overload, rather than the first method with the name
+		if !strings.HasPrefix(common.Bytes2Hex(packed), common.Bytes2Hex(method.ID)) || len(packed) != 4+32*len(args) {
+			t.Errorf("%s: unexpected call data %x", sig, packed)
+		}
+	}
+}
+
+const testConstructorABI = `[
+	{"type": "constructor", "stateMutability": "nonpayable", "inputs": [
+		{"name": "owner", "type": "address"}, {"name": "supply", "type": "uint256"},
+		{"name": "name", "type": "string"}, {"name": "holders", "type": "address[]"}
+	]}
+]`
+
+func TestPackConstructor(t *testing.T) {
+	contractABI, err := abi.JSON(strings.NewReader(testConstructorABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := "0x0102030405060708090a0b0c0d0e0f1011121314"
+	c := &CompiledSolidity{ABI: contractABI}
+	// Converted as method args are, including JSON values for arrays
+	if err := c.PackConstructor([]interface{}{owner, "1000000000000000000000", "Token", `["` + owner + `"]`}); err != nil {
+		t.Fatal(err)
+	}
+	// The packed args have no selector, just the ABI encoded values
+	unpacked, err := contractABI.Constructor.Inputs.Unpack(c.PackedConstruct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unpacked[0].(common.Address) != common.HexToAddress(owner) || unpacked[1].(*big.Int).String() != "1000000000000000000000" ||
+		unpacked[2].(string) != "Token" || len(unpacked[3].([]common.Address)) != 1 {
+		t.Errorf("unexpected constructor args %v", unpacked)
+	}
+
+	tests := []struct {
+		name string
+		args []interface{}
+		err  string
+	}{
+		{"missing", []interface{}{owner}, "constructor requires 4 args (1 supplied)"},
+		{"invalid", []interface{}{owner, "-1", "Token", "[]"}, "invalid value for arg 1 (supply)"},
+		{"not an array", []interface{}{owner, "1", "Token", owner}, "invalid value for arg 3 (holders)"},
+	}
+	for _, test := range tests {
+		if err := (&CompiledSolidity{ABI: contractABI}).PackConstructor(test.args); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing '%s', got %v", test.name, test.err, err)
+		}
+	}
+
+	// A contract without a constructor takes no args
+	noConstructor := &CompiledSolidity{ABI: testOverloadsContractABI(t)}
+	if err := noConstructor.PackConstructor(nil); err != nil || len(noConstructor.PackedConstruct) != 0 {
+		t.Errorf("unexpected constructor args %x: %v", noConstructor.PackedConstruct, err)
+	}
+	if err := noConstructor.PackConstructor([]interface{}{"1"}); err == nil || !strings.Contains(err.Error(), "constructor requires 0 args (1 supplied)") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+const testSolcOutput = `{
+	"errors": [
+		{"severity": "warning", "type": "Warning", "component": "general", "errorCode": "2072", "message": "Unused local variable.",
+			"sourceLocation": {"file": "Store.sol", "start": 10, "end": 20}},
+		{"severity": "info", "type": "Info", "component": "general", "message": "Optimizer enabled."}
+	],
+	"sources": {"Store.sol": {"id": 0}, "lib/Math.sol": {"id": 1}},
+	"contracts": {
+		"Store.sol": {"Store": {
+			"abi": [{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "x", "type": "uint256"}], "outputs": []}],
+			"metadata": "{}",
+			"evm": {
+				"bytecode": {"object": "6080", "sourceMap": "1:2:0:-"},
+				"deployedBytecode": {"object": "6001", "sourceMap": "3:4:0:-", "immutableReferences": {"7": [{"start": 5, "length": 32}]}},
+				"methodIdentifiers": {"set(uint256)": "60fe47b1"}
+			}
+		}},
+		"lib/Math.sol": {"Math": {"abi": [], "evm": {"bytecode": {"object": "6002"}, "deployedBytecode": {"object": "6003"}}}}
+	}
+}`
+
+// fakeSolc writes a script that behaves like solc --standard-json, recording the
+// arguments and the input it is run with
+func fakeSolc(t *testing.T, output string) (solcPath, argsFile, inputFile string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake solc is a shell script")
+	}
+	dir := t.TempDir()
+	argsFile = filepath.Join(dir, "args")
+	inputFile = filepath.Join(dir, "input.json")
+	writeTestFile(t, dir, "output.json", output, 0600)
+	solcPath = writeTestFile(t, dir, "solc", `#!/bin/sh
+if [ "$1" = "--version" ]; then echo "solc, the solidity compiler commandline interface"; echo "Version: 0.8.19+commit.7dd6d404.Linux.g++"; exit 0; fi
+echo "$@" > "`+argsFile+`"
+cat > "`+inputFile+`"
+cat "`+filepath.Join(dir, "output.json")+`"
+`, 0755)
+	return solcPath, argsFile, inputFile
+}
+
+func TestNewSolcInput(t *testing.T) {
+	opts := &CompilerOptions{
+		Remappings:    []string{"@openzeppelin/=node_modules/@openzeppelin/"},
+		Optimize:      true,
+		OptimizerRuns: 1000,
+		ViaIR:         true,
+		EVMVersion:    "paris",
+	}
+	inputJSON, _ := json.Marshal(newSolcInput("contracts/Store.sol", []byte("contract Store {}"), opts))
+	var input map[string]interface{}
+	json.Unmarshal(inputJSON, &input)
+	settings := input["settings"].(map[string]interface{})
+	if input["language"] != "Solidity" || input["sources"].(map[string]interface{})["contracts/Store.sol"] == nil {
+		t.Errorf("unexpected input %s", inputJSON)
+	}
+	if !reflect.DeepEqual(settings["remappings"], []interface{}{"@openzeppelin/=node_modules/@openzeppelin/"}) ||
+		!reflect.DeepEqual(settings["optimizer"], map[string]interface{}{"enabled": true, "runs": float64(1000)}) ||
+		settings["viaIR"] != true || settings["evmVersion"] != "paris" {
+		t.Errorf("unexpected settings %s", inputJSON)
+	}
+
+	// Settings that are not specified are left to the solc defaults
+	inputJSON, _ = json.Marshal(newSolcInput("Store.sol", nil, &CompilerOptions{}))
+	for _, setting := range []string{`"remappings"`, `"viaIR"`} {
+		if strings.Contains(string(inputJSON), setting) {
+			t.Errorf("unexpected %s setting in %s", setting, inputJSON)
+		}
+	}
+}
+
+func TestCompileContractStandardJSON(t *testing.T) {
+	srcDir := t.TempDir()
+	solFile := writeTestFile(t, srcDir, "Store.sol", "pragma solidity ^0.8.0;\ncontract Store {}\n", 0600)
+	writeTestFile(t, srcDir, "Math.sol", "library Math {}", 0600)
+	solcPath, argsFile, inputFile := fakeSolc(t, testSolcOutput)
+	opts := &CompilerOptions{
+		SolcPath:      solcPath,
+		Remappings:    []string{"lib/=" + srcDir + "/"},
+		Optimize:      true,
+		OptimizerRuns: 200,
+		BasePath:      srcDir,
+		IncludePaths:  []string{"node_modules"},
+		CacheDir:      t.TempDir(),
+		NoCache:       true,
+	}
+	c, err := CompileContract(solFile, opts, "", "set", []interface{}{"1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, _ := ioutil.ReadFile(argsFile)
+	expectedArgs := "--standard-json --base-path " + srcDir + " --include-path node_modules --allow-paths .," + srcDir + ",node_modules"
+	if strings.TrimSpace(string(args)) != expectedArgs {
+		t.Errorf("unexpected solc args '%s'", strings.TrimSpace(string(args)))
+	}
+	inputJSON, _ := ioutil.ReadFile(inputFile)
+	var input solcInput
+	if err := json.Unmarshal(inputJSON, &input); err != nil {
+		t.Fatal(err)
+	}
+	// The source unit name is relative to the base path
+	if _, ok := input.Sources["Store.sol"]; !ok || !reflect.DeepEqual(input.Settings.Remappings, opts.Remappings) ||
+		input.Settings.Optimizer != (solcOptimizer{Enabled: true, Runs: 200}) || input.Settings.EVMVersion != "byzantium" {
+		t.Errorf("unexpected solc input %s", inputJSON)
+	}
+
+	// Only the contract in the main file is a candidate, and the imported library can be linked
+	if c.Name != "Store.sol:Store" || c.Compiled != "0x6080" || c.RuntimeCode != "0x6001" || len(c.PackedCall) != 4+32 {
+		t.Errorf("unexpected contract %+v", c)
+	}
+	if c.Libraries["lib/Math.sol:Math"] != "0x6002" || !reflect.DeepEqual(c.Immutables, []CodeRange{{5, 32}}) {
+		t.Errorf("unexpected libraries %v, immutables %v", c.Libraries, c.Immutables)
+	}
+	if c.ContractInfo.CompilerVersion != "0.8.19" || !strings.Contains(c.ContractInfo.CompilerOptions, `"runs":200`) {
+		t.Errorf("unexpected contract info %+v", c.ContractInfo)
+	}
+	// Warnings are kept with their details, and info messages are not warnings
+	if len(c.Warnings) != 1 || c.Warnings[0].ErrorCode != "2072" || c.Warnings[0].String() != "Store.sol (offset 10-20): Warning: Unused local variable." {
+		t.Errorf("unexpected warnings %+v", c.Warnings)
+	}
+	if c.SourceFiles[0] != solFile {
+		t.Errorf("unexpected source files %v", c.SourceFiles)
+	}
+}
+
+func TestCompileContractErrors(t *testing.T) {
+	solFile := writeTestFile(t, t.TempDir(), "Store.sol", "contract Store {", 0600)
+	solcPath, _, _ := fakeSolc(t, `{"errors": [
+		{"severity": "error", "type": "ParserError", "component": "general", "message": "Expected '}' but got end of source",
+			"sourceLocation": {"file": "Store.sol", "start": 16, "end": 16}},
+		{"severity": "warning", "type": "Warning", "component": "general", "message": "SPDX license identifier not provided."},
+		{"severity": "error", "type": "DeclarationError", "component": "general", "message": "Undeclared identifier."}
+	]}`)
+	_, err := CompileContract(solFile, &CompilerOptions{SolcPath: solcPath, BasePath: filepath.Dir(solFile), CacheDir: t.TempDir(), NoCache: true}, "", "", nil)
+	compileErr, ok := err.(*CompilationError)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The errors are structured, without the warnings
+	if len(compileErr.Messages) != 2 || compileErr.Messages[0].Type != "ParserError" || compileErr.Messages[1].SourceLocation != nil {
+		t.Errorf("unexpected messages %+v", compileErr.Messages)
+	}
+	expected := "solc reported 2 error(s):\n" +
+		"Store.sol (offset 16-16): ParserError: Expected '}' but got end of source\n" +
+		"DeclarationError: Undeclared identifier."
+	if err.Error() != expected {
+		t.Errorf("expected '%s', got '%s'", expected, err)
+	}
+
+	solcPath, _, _ = fakeSolc(t, `not json`)
+	if _, err := CompileContract(solFile, &CompilerOptions{SolcPath: solcPath, CacheDir: t.TempDir(), NoCache: true}, "", "", nil); err == nil || !strings.Contains(err.Error(), "failed to parse solc output") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// StrategyPin pins each worker to a node, moving to the next healthy node if it fails
+	StrategyPin = "pin"
+	// StrategyRoundRobin sends each RPC call to the next healthy node in turn
+	StrategyRoundRobin = "round-robin"
+	// StrategyLatency sends each RPC call to the healthy node with the lowest latency
+	StrategyLatency = "latency"
+
+	// maxEndpointFailures is the number of consecutive failed calls before a node is unhealthy
+	maxEndpointFailures = 3
+)
+
+var endpointNameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// endpoint is a connection to one of the nodes
+type endpoint struct {
+	URL       string
+	Name      string
+	RPC       *rpc.Client
+	Streaming bool
+	latency   int64
+	failures  int32
+	unhealthy int32
+}
+
+// endpointPool balances the workers across the nodes, and health checks them
+type endpointPool struct {
+	strategy  string
+	endpoints []*endpoint
+	next      uint64
+	stop      chan struct{}
+	stopped   sync.WaitGroup
+}
+
+// endpointName is the host and port of the URL, or the IPC socket file name, without
+// any credentials and safe to use in metric names
+func endpointName(rawURL string) string {
+	name := filepath.Base(rawURL)
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		name = u.Host
+	}
+	return endpointNameSanitizer.ReplaceAllString(name, "_")
+}
+
+// dialEndpoints connects to each of the nodes
+func (e *Exerciser) dialEndpoints() (*endpointPool, error) {
+	p := &endpointPool{
+		strategy: e.URLStrategy,
+		stop:     make(chan struct{}),
+	}
+	switch p.strategy {
+	case "":
+		p.strategy = StrategyPin
+	case StrategyPin, StrategyRoundRobin, StrategyLatency:
+	default:
+		return nil, fmt.Errorf("invalid URL strategy '%s' (%s, %s or %s)", e.URLStrategy, StrategyPin, StrategyRoundRobin, StrategyLatency)
+	}
+	names := make(map[string]bool)
+	for i, url := range e.URLs {
+		rpcClient, streaming, err := e.dialRPC(url)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		ep := &endpoint{URL: url, Name: endpointName(url), RPC: rpcClient, Streaming: streaming}
+		if names[ep.Name] {
+			ep.Name = fmt.Sprintf("%s_%d", ep.Name, i)
+		}
+		names[ep.Name] = true
+		p.endpoints = append(p.endpoints, ep)
+		log.Debug("Connected. URL=", redactURL(url), " Endpoint=", ep.Name)
+		if isWebSocketURL(url) && e.authorizer != nil && e.authorizer.refreshes() {
+			log.Warnf("Endpoint %s uses WebSocket, so a refreshed bearer token is only sent when it reconnects", ep.Name)
+		}
+	}
+	if len(p.endpoints) > 1 && e.HealthCheckInterval > 0 {
+		p.stopped.Add(1)
+		go p.healthCheck(time.Duration(e.HealthCheckInterval)*time.Second, time.Duration(e.RPCTimeout)*time.Second)
+	}
+	return p, nil
+}
+
+// Close stops health checking, and closes the connections
+func (p *endpointPool) Close() {
+	close(p.stop)
+	p.stopped.Wait()
+	for _, ep := range p.endpoints {
+		ep.RPC.Close()
+	}
+}
+
+func (ep *endpoint) healthy() bool {
+	return atomic.LoadInt32(&ep.unhealthy) == 0
+}
+
+// pick chooses the node for the next call from the worker, using the strategy.
+// If every node is unhealthy, the worker's own node is used
+func (p *endpointPool) pick(workerIndex int) *endpoint {
+	n := len(p.endpoints)
+	start := workerIndex % n
+	switch p.strategy {
+	case StrategyRoundRobin:
+		start = int(atomic.AddUint64(&p.next, 1) % uint64(n))
+	case StrategyLatency:
+		var fastest *endpoint
+		for _, ep := range p.endpoints {
+			if ep.healthy() && (fastest == nil || atomic.LoadInt64(&ep.latency) < atomic.LoadInt64(&fastest.latency)) {
+				fastest = ep
+			}
+		}
+		if fastest != nil {
+			return fastest
+		}
+	}
+	for i := 0; i < n; i++ {
+		if ep := p.endpoints[(start+i)%n]; ep.healthy() {
+			return ep
+		}
+	}
+	return p.endpoints[start]
+}
+
+// isEndpointFailure is true for errors that mean the node is not serving requests, rather
+// than an error response from the node. Rate limiting with a 429 is not a failure
+func isEndpointFailure(err error) bool {
+	switch e := err.(type) {
+	case rpc.Error:
+		return false
+	case rpc.HTTPError:
+		return e.StatusCode != http.StatusTooManyRequests
+	}
+	return true
+}
+
+// isUndelivered is true when the request never reached the node, because the connection
+// could not be made. Only then is it safe to send a transaction to another node, as
+// any other failure could be after the node received the transaction
+func isUndelivered(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// sendMethods submit transactions, so must not be repeated on another node once delivered
+var sendMethods = map[string]bool{
+	"eth_sendTransaction":    true,
+	"eth_sendRawTransaction": true,
+}
+
+// record updates the latency and health of the node after a call
+func (ep *endpoint) record(err error, callTime time.Duration) {
+	if err != nil && isEndpointFailure(err) {
+		if atomic.AddInt32(&ep.failures, 1) >= maxEndpointFailures && atomic.CompareAndSwapInt32(&ep.unhealthy, 0, 1) {
+			log.Warnf("Endpoint %s is unhealthy after %d failed calls: %s", ep.Name, maxEndpointFailures, err)
+		}
+		return
+	}
+	atomic.StoreInt32(&ep.failures, 0)
+	// Exponentially weighted moving average, so the latency follows changes in load
+	latency := atomic.LoadInt64(&ep.latency)
+	if latency == 0 {
+		latency = callTime.Nanoseconds()
+	} else {
+		latency = (latency*7 + callTime.Nanoseconds()) / 8
+	}
+	atomic.StoreInt64(&ep.latency, latency)
+}
+
+// healthCheck periodically queries the block number from each node, marking the
+// nodes unhealthy while they fail, and healthy again when they recover
+func (p *endpointPool) healthCheck(interval, timeout time.Duration) {
+	defer p.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		for _, ep := range p.endpoints {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			start := time.Now()
+			var blockNumber hexutil.Uint64
+			err := redactError(ep.RPC.CallContext(ctx, &blockNumber, "eth_blockNumber"))
+			cancel()
+			if err != nil {
+				if atomic.CompareAndSwapInt32(&ep.unhealthy, 0, 1) {
+					log.Warnf("Endpoint %s failed health check: %s", ep.Name, err)
+				}
+				continue
+			}
+			ep.record(nil, time.Since(start))
+			if atomic.CompareAndSwapInt32(&ep.unhealthy, 1, 0) {
+				log.Infof("Endpoint %s is healthy. Block=%d", ep.Name, blockNumber)
+			}
+		}
+	}
+}
+
+// incrEndpointCounter counts a call to a node, with the node as a tag for Telegraf
+// naming, or in the metric path for Graphite naming
+func (w *Worker) incrEndpointCounter(ep *endpoint, name string) {
+	if w.Exerciser.metrics != nil {
+		if w.telegrafMetricsFormat {
+			w.Exerciser.metrics.Increment(w.telegrafMetricsFormatter("endpoint."+name) + ",endpoint=" + ep.Name)
+		} else {
+			w.Exerciser.metrics.Increment(w.graphiteMetricsFormatter("endpoint." + ep.Name + "." + name))
+		}
+	}
+}
+
+func (w *Worker) emitEndpointTiming(ep *endpoint, name string, timing time.Duration) {
+	if w.Exerciser.metrics != nil {
+		if w.telegrafMetricsFormat {
+			w.Exerciser.metrics.Gauge(w.telegrafMetricsFormatter("endpoint."+name)+",endpoint="+ep.Name, int(timing.Nanoseconds()/1000000))
+		} else {
+			w.Exerciser.metrics.Gauge(w.graphiteMetricsFormatter("endpoint."+ep.Name+"."+name), int(timing.Nanoseconds()/1000000))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// DecodedEvent is an event log decoded against the contract ABI
+type DecodedEvent struct {
+	Event  *abi.Event
+	Values DecodedValues
+}
+
+func (e *DecodedEvent) String() string {
+	return fmt.Sprintf("%s(%s)", e.Event.RawName, e.Values)
+}
+
+// FindEvent returns the event in the ABI with the name or full signature supplied
+func FindEvent(contractABI abi.ABI, eventName string) (*abi.Event, error) {
+	names := make([]string, 0, len(contractABI.Events))
+	for name := range contractABI.Events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	overloads := make([]abiOverload, len(names))
+	for i, name := range names {
+		event := contractABI.Events[name]
+		overloads[i] = abiOverload{key: name, rawName: event.RawName, sig: event.Sig}
+	}
+	key, err := resolveOverload("event", eventName, overloads)
+	if err != nil {
+		return nil, err
+	}
+	event := contractABI.Events[key]
+	return &event, nil
+}
+
+// isHashedTopic is true for indexed types that are stored as the hash of their value
+func isHashedTopic(t abi.Type) bool {
+	switch t.T {
+	case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return true
+	}
+	return false
+}
+
+// DecodeLog decodes an event log against the events in the ABI. Indexed arguments are
+// decoded from the topics, except dynamic types where only the hash is available
+func DecodeLog(contractABI abi.ABI, log *types.Log) (*DecodedEvent, error) {
+	if len(log.Topics) == 0 {
+		return nil, fmt.Errorf("anonymous event logs cannot be decoded")
+	}
+	event, err := contractABI.EventByID(log.Topics[0])
+	if err != nil {
+		return nil, fmt.Errorf("event %s not found in ABI", log.Topics[0].Hex())
+	}
+
+	nonIndexed, err := DecodeValues(event.Inputs.NonIndexed(), log.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data for event %s: %s", event.Sig, err)
+	}
+
+	values := make(DecodedValues, 0, len(event.Inputs))
+	topicIndex := 1
+	for i, input := range event.Inputs {
+		if !input.Indexed {
+			values = append(values, nonIndexed[0])
+			nonIndexed = nonIndexed[1:]
+			continue
+		}
+		if topicIndex >= len(log.Topics) {
+			return nil, fmt.Errorf("missing topic for indexed argument %d of event %s", i, event.Sig)
+		}
+		topic := log.Topics[topicIndex]
+		topicIndex++
+		name := input.Name
+		if name == "" {
+			name = fmt.Sprintf("output%d", i)
+		}
+		value := DecodedValue{Name: name, Type: input.Type.String()}
+		if isHashedTopic(input.Type) {
+			value.Value = topic.Hex()
+		} else {
+			unpacked, err := (abi.Arguments{{Type: input.Type}}).UnpackValues(topic.Bytes())
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode topic for argument %d of event %s: %s", i, event.Sig, err)
+			}
+			value.Value = formatValue(input.Type, reflect.ValueOf(unpacked[0]))
+		}
+		values = append(values, value)
+	}
+	return &DecodedEvent{Event: event, Values: values}, nil
+}
+
+// checkReceiptEvents logs the events in the receipt of a successful transaction, and
+// returns false if the expected event was not emitted by the contract. The transaction
+// is counted as a success or failure here, once, as the events decide which it is
+func (w *Worker) checkReceiptEvents(receipt *txnReceipt, expected *abi.Event) bool {
+	found := false
+	for _, log := range receipt.Logs {
+		event, err := DecodeLog(w.CompiledContract.ABI, log)
+		if err != nil {
+			w.debug("TX:%s Log %d from %s: %s", receipt.TransactionHash.Hex(), log.Index, log.Address.Hex(), err)
+			continue
+		}
+		w.debug("TX:%s Log %d from %s: %s", receipt.TransactionHash.Hex(), log.Index, log.Address.Hex(), event)
+		if expected != nil && event.Event.ID == expected.ID && w.Exerciser.To != nil && log.Address == *w.Exerciser.To {
+			found = true
+		}
+	}
+	if expected != nil && !found {
+		w.error("TX:%s did not emit expected event %s", receipt.TransactionHash.Hex(), expected.Sig)
+		w.incrCounter("tx.noevent")
+		w.incrCounter("tx.fail")
+		return false
+	}
+	w.incrCounter("tx.success")
+	return true
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	ecrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+const testEventsABI = `[
+	{"type": "event", "name": "Transfer", "inputs": [
+		{"name": "from", "type": "address", "indexed": true},
+		{"name": "to", "type": "address", "indexed": true},
+		{"name": "value", "type": "uint256", "indexed": false}
+	]},
+	{"type": "event", "name": "Transfer", "inputs": [
+		{"name": "from", "type": "address", "indexed": true},
+		{"name": "value", "type": "uint256", "indexed": false}
+	]},
+	{"type": "event", "name": "Labelled", "inputs": [
+		{"name": "label", "type": "string", "indexed": true},
+		{"name": "", "type": "uint8", "indexed": true},
+		{"name": "note", "type": "string", "indexed": false}
+	]},
+	{"type": "event", "name": "Approval", "inputs": []},
+	{"type": "function", "name": "transfer", "stateMutability": "nonpayable", "inputs": [
+		{"name": "to", "type": "address"}, {"name": "value", "type": "uint256"}
+	], "outputs": []},
+	{"type": "function", "name": "transfer", "stateMutability": "nonpayable", "inputs": [
+		{"name": "to", "type": "address"}
+	], "outputs": []}
+]`
+
+func testEventsContractABI(t *testing.T) abi.ABI {
+	contractABI, err := abi.JSON(strings.NewReader(testEventsABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contractABI
+}
+
+func TestFindEvent(t *testing.T) {
+	contractABI := testEventsContractABI(t)
+	tests := []struct {
+		name string
+		sig  string
+		err  string
+	}{
+		{"Approval", "Approval()", ""},
+		{"Transfer(address,address,uint256)", "Transfer(address,address,uint256)", ""},
+		{"Transfer(address, uint256)", "Transfer(address,uint256)", ""},
+		{"Transfer0", "Transfer(address,uint256)", ""},
+		{"Labelled", "Labelled(string,uint8,string)", ""},
+		{"Transfer", "", "event 'Transfer' is overloaded, please specify the signature: Transfer(address,address,uint256), Transfer(address,uint256)"},
+		{"Transfer(uint256)", "", "event 'Transfer(uint256)' not found. Signatures: "},
+		{"Missing", "", "event 'Missing' not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := FindEvent(contractABI, test.name)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing '%s', got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if event.Sig != test.sig {
+				t.Errorf("expected %s, got %s", test.sig, event.Sig)
+			}
+		})
+	}
+}
+
+func TestDecodeLog(t *testing.T) {
+	contractABI := testEventsContractABI(t)
+	from := common.HexToAddress("0x0102030405060708090a0b0c0d0e0f1011121314")
+	to := common.HexToAddress("0x1112131415161718191a1b1c1d1e1f2021222324")
+	transfer, _ := FindEvent(contractABI, "Transfer(address,address,uint256)")
+	data, _ := transfer.Inputs.NonIndexed().Pack(big.NewInt(1000))
+
+	event, err := DecodeLog(contractABI, &types.Log{
+		Topics: []common.Hash{transfer.ID, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:   data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Values) != 3 {
+		t.Fatalf("unexpected values %+v", event.Values)
+	}
+	expected := DecodedValues{
+		{Name: "from", Type: "address", Value: from.Hex()},
+		{Name: "to", Type: "address", Value: to.Hex()},
+		{Name: "value", Type: "uint256", Value: "1000"},
+	}
+	for i := range expected {
+		if event.Values[i] != expected[i] {
+			t.Errorf("value %d: expected %+v, got %+v", i, expected[i], event.Values[i])
+		}
+	}
+
+	// Indexed strings are only available as their hash, and unnamed arguments are numbered
+	labelled, _ := FindEvent(contractABI, "Labelled")
+	labelHash := ecrypto.Keccak256Hash([]byte("label"))
+	data, _ = labelled.Inputs.NonIndexed().Pack("a note")
+	event, err = DecodeLog(contractABI, &types.Log{
+		Topics: []common.Hash{labelled.ID, labelHash, common.BigToHash(big.NewInt(7))},
+		Data:   data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = DecodedValues{
+		{Name: "label", Type: "string", Value: labelHash.Hex()},
+		{Name: "arg1", Type: "uint8", Value: "7"},
+		{Name: "note", Type: "string", Value: "a note"},
+	}
+	for i := range expected {
+		if event.Values[i] != expected[i] {
+			t.Errorf("value %d: expected %+v, got %+v", i, expected[i], event.Values[i])
+		}
+	}
+}
+
+func TestDecodeLogErrors(t *testing.T) {
+	contractABI := testEventsContractABI(t)
+	transfer, _ := FindEvent(contractABI, "Transfer(address,address,uint256)")
+	tests := []struct {
+		name string
+		log  *types.Log
+		err  string
+	}{
+		{"anonymous", &types.Log{}, "anonymous event logs cannot be decoded"},
+		{"unknown", &types.Log{Topics: []common.Hash{{1}}}, "not found in ABI"},
+		{"missing topic", &types.Log{Topics: []common.Hash{transfer.ID, {}}, Data: make([]byte, 32)}, "missing topic for indexed argument 1"},
+		{"short data", &types.Log{Topics: []common.Hash{transfer.ID, {}, {}}, Data: []byte{1}}, "failed to decode data"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := DecodeLog(contractABI, test.log); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error containing '%s', got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// runEventsWorker runs a single transaction against a node that emits the logs,
+// and returns the worker counters
+func runEventsWorker(t *testing.T, logs []*types.Log) (map[string]int, *Exerciser) {
+	contractABI := testEventsContractABI(t)
+	node := newTestNode(t)
+	node.logs = logs
+	e := newTestExerciser(node)
+	metrics := newTestMetrics(t, e)
+	to := common.HexToAddress("0xc0de")
+	e.To = &to
+	method, _ := FindMethod(contractABI, "transfer(address)")
+	expected, _ := FindEvent(contractABI, "Approval")
+	e.methods = []*workloadMethod{{Method: method, PackedCall: method.ID, Weight: 1, ExpectedEvent: expected}}
+	w := newTestWorker(t, e)
+	w.CompiledContract = &CompiledSolidity{ABI: contractABI}
+	w.Run()
+	return metrics.counts(t, e, w.Name), e
+}
+
+func TestRunCountsExpectedEventOnce(t *testing.T) {
+	approval := testEventsContractABI(t).Events["Approval"]
+	counts, e := runEventsWorker(t, []*types.Log{{Address: common.HexToAddress("0xc0de"), Topics: []common.Hash{approval.ID}}})
+	if counts["tx.success"] != 1 || counts["tx.fail"] != 0 || counts["tx.noevent"] != 0 || e.TotalSuccesses != 1 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+
+	// The event from another contract does not count
+	counts, e = runEventsWorker(t, []*types.Log{{Address: common.HexToAddress("0xbeef"), Topics: []common.Hash{approval.ID}}})
+	if counts["tx.success"] != 0 || counts["tx.fail"] != 1 || counts["tx.noevent"] != 1 || e.TotalFailures != 1 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+}
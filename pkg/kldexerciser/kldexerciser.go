@@ -20,15 +20,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net"
-	"net/http"
+	"math/rand"
+	"os"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	ecrypto "github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/rpc"
 
 	"github.com/alexcesaro/statsd"
 	log "github.com/sirupsen/logrus"
@@ -36,41 +36,86 @@ import (
 
 // Exerciser is the Kaleido go-ethereum exerciser
 type Exerciser struct {
-	URL               string
-	Call              bool
-	EstimateGas       bool
-	Contract          string
-	To                *common.Address
-	ContractName      string
-	Method            string
-	Args              []string
-	SolidityFile      string
-	ABI               string
-	Amount            int64
-	EVMVersion        string
-	GasPrice          int64
-	Gas               int64
-	StatsdServer      string
-	StatsdFlushPeriod int64
-	StatsdTelegraf    bool
-	StatsdQualifier   string
-	RPCTimeout        int
-	PrivateFrom       string
-	PrivateFor        []string
-	Loops             int
-	TxnsPerLoop       int
-	ReceiptWaitMin    int
-	ReceiptWaitMax    int
-	Workers           int
-	DebugLevel        int
-	ExternalSign      bool
-	ExternalSignJSON  string
-	ChainID           int64
-	Accounts          []string
-	TotalSuccesses    uint64
-	TotalFailures     uint64
-	Nonce             int64
-	metrics           *statsd.Client
+	URLs                []string
+	URLStrategy         string
+	HealthCheckInterval int
+	TLSCAFile           string
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSServerName       string
+	TLSInsecure         bool
+	Headers             []string
+	TokenFile           string
+	TokenEnv            string
+	TokenCommand        string
+	TokenRefresh        int
+	Call                bool
+	CallOutput          string
+	EstimateGas         bool
+	Contract            string
+	To                  *common.Address
+	ContractName        string
+	Method              string
+	Mix                 string
+	Scenario            string
+	Args                []string
+	ArgsFile            string
+	ConstructorArgs     []string
+	ExpectEvent         string
+	ExportFile          string
+	Links               []string
+	SolidityFile        string
+	ArtifactFile        string
+	ABIFile             string
+	ABI                 string
+	Amount              int64
+	EVMVersion          string
+	SolcPath            string
+	SolcReleasesDir     string
+	Optimize            bool
+	OptimizerRuns       int
+	ViaIR               bool
+	Remappings          []string
+	BasePath            string
+	IncludePaths        []string
+	CompileCacheDir     string
+	NoCompileCache      bool
+	VyperPath           string
+	GasPrice            int64
+	Gas                 int64
+	StatsdServer        string
+	StatsdFlushPeriod   int64
+	StatsdTelegraf      bool
+	StatsdQualifier     string
+	RPCTimeout          int
+	PrivateFrom         string
+	PrivateFor          []string
+	Loops               int
+	TxnsPerLoop         int
+	ReceiptWaitMin      int
+	ReceiptWaitMax      int
+	Workers             int
+	DebugLevel          int
+	ExternalSign        bool
+	ExternalSignJSON    string
+	KeystoreDir         string
+	KeystorePassFile    string
+	KeystorePassEnv     string
+	KeystoreLight       bool
+	ChainID             int64
+	Accounts            []string
+	TraceFailures       bool
+	VerifyCode          bool
+	TotalSuccesses      uint64
+	TotalFailures       uint64
+	txSeq               uint64
+	Nonce               int64
+	metrics             *statsd.Client
+	authorizer          *authorizer
+	libraryLinks        map[string]common.Address
+	expectedEvent       *abi.Event
+	methods             []*workloadMethod
+	totalWeight         int
 }
 
 func max(a, b int) int {
@@ -81,6 +126,9 @@ func max(a, b int) int {
 }
 
 func (e *Exerciser) ensurePrivateKeys() (keys []*ecdsa.PrivateKey, err error) {
+	if e.KeystoreDir != "" {
+		return e.ensureKeystoreKeys()
+	}
 	preGenerated := 0
 
 	// Load existing keys
@@ -119,7 +167,11 @@ func (e *Exerciser) ensurePrivateKeys() (keys []*ecdsa.PrivateKey, err error) {
 			serializedKeys[i] = hex.EncodeToString(ecrypto.FromECDSA(keys[i]))
 		}
 		jsonBytes, _ := json.MarshalIndent(serializedKeys, "", "")
-		err := ioutil.WriteFile(e.ExternalSignJSON, jsonBytes, 0777)
+		err := ioutil.WriteFile(e.ExternalSignJSON, jsonBytes, 0600)
+		if err == nil {
+			// WriteFile only sets the mode on new files, and this file holds private keys
+			err = os.Chmod(e.ExternalSignJSON, 0600)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("unable to write %s: %s", e.ExternalSignJSON, err)
 		}
@@ -128,6 +180,34 @@ func (e *Exerciser) ensurePrivateKeys() (keys []*ecdsa.PrivateKey, err error) {
 	return
 }
 
+// ensureKeystoreKeys loads the keys from the encrypted keystore files, and generates
+// a keystore file for each worker without one
+func (e *Exerciser) ensureKeystoreKeys() ([]*ecdsa.PrivateKey, error) {
+	if e.ExternalSignJSON != "" {
+		return nil, fmt.Errorf("only one of a keys file or keystore directory can be specified")
+	}
+	password, err := e.keystorePassword()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := e.loadKeystore(password)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Externally signing using %d keys from keystore %s", len(keys), e.KeystoreDir)
+	for i := len(keys); i < e.Workers; i++ {
+		key, err := e.generateAccount()
+		if err != nil {
+			return nil, err
+		}
+		if err = e.storeKeystore(key, password); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
 // generateAccount generates a new account for this worker to use in external signing
 func (e *Exerciser) generateAccount() (*ecdsa.PrivateKey, error) {
 	key, err := ecrypto.GenerateKey()
@@ -137,15 +217,64 @@ func (e *Exerciser) generateAccount() (*ecdsa.PrivateKey, error) {
 	return key, nil
 }
 
+func toInterfaceArgs(strargs []string) []interface{} {
+	args := make([]interface{}, len(strargs))
+	for i, arg := range strargs {
+		args[i] = arg
+	}
+	return args
+}
+
+// methodArgs returns the arguments for the method, from the JSON array in the
+// args file if one is specified, or the command line strings
+func (e *Exerciser) methodArgs() ([]interface{}, error) {
+	if e.ArgsFile == "" {
+		return toInterfaceArgs(e.Args), nil
+	}
+	if len(e.Args) > 0 {
+		return nil, fmt.Errorf("args cannot be specified on the command line and in an args file")
+	}
+	jsonData, err := ioutil.ReadFile(e.ArgsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read args file %s: %s", e.ArgsFile, err)
+	}
+	parsed, err := ParseJSONArg(jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse args file %s: %s", e.ArgsFile, err)
+	}
+	args, ok := parsed.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("args file %s must contain a JSON array of arguments", e.ArgsFile)
+	}
+	return args, nil
+}
+
+func (e *Exerciser) compilerOptions() *CompilerOptions {
+	return &CompilerOptions{
+		SolcPath:        e.SolcPath,
+		SolcReleasesDir: e.SolcReleasesDir,
+		EVMVersion:      e.EVMVersion,
+		Optimize:        e.Optimize,
+		OptimizerRuns:   e.OptimizerRuns,
+		ViaIR:           e.ViaIR,
+		Remappings:      e.Remappings,
+		BasePath:        e.BasePath,
+		IncludePaths:    e.IncludePaths,
+		CacheDir:        e.CompileCacheDir,
+		NoCache:         e.NoCompileCache,
+		VyperPath:       e.VyperPath,
+	}
+}
+
 // GetNetworkID returns the network ID from the node
 func (e *Exerciser) GetNetworkID() (int64, error) {
-	rpc, err := rpc.Dial(e.URL)
+	rpc, _, err := e.dialRPC(e.URLs[0])
 	if err != nil {
-		return 0, fmt.Errorf("connect to %s failed: %s", e.URL, err)
+		return 0, err
 	}
 	defer rpc.Close()
 	var strNetworkID string
-	err = rpc.Call(&strNetworkID, "net_version")
+	err = redactError(rpc.Call(&strNetworkID, "net_version"))
 	if err != nil {
 		return 0, fmt.Errorf("failed to query network ID (to use as chain ID in EIP155 signing): %s", err)
 	}
@@ -159,14 +288,185 @@ func (e *Exerciser) GetNetworkID() (int64, error) {
 // Start initializes the workers for the specified config
 func (e *Exerciser) Start() (err error) {
 
+	if e.CallOutput != "text" && e.CallOutput != "json" && e.CallOutput != "" {
+		return fmt.Errorf("invalid call output format '%s' (text or json)", e.CallOutput)
+	}
+	if e.Scenario != "" {
+		return e.RunScenario()
+	}
+
+	compiled, err := e.loadContract()
+	if err != nil {
+		return err
+	}
+
+	keys, endpoints, err := e.connect()
+	if err != nil {
+		return err
+	}
+	defer endpoints.Close()
+
+	workers, err := e.newWorkers(endpoints, compiled, keys)
+	if err != nil {
+		return err
+	}
+
+	if e.Contract == "" {
+		if e.To, err = e.deployContract(workers); err != nil {
+			return err
+		}
+	} else {
+		if !common.IsHexAddress(e.Contract) {
+			return fmt.Errorf("invalid contract address: %s", e.Contract)
+		}
+		contractAddr := common.HexToAddress(e.Contract)
+		e.To = &contractAddr
+		if e.VerifyCode {
+			if err = workers[0].verifyDeployedCode(); err != nil {
+				return err
+			}
+		}
+	}
+	log.Info("Contract address=", e.To.Hex())
+
+	return e.runWorkers(workers)
+}
+
+// checkSources checks exactly one source of the contract is specified
+func (e *Exerciser) checkSources() error {
+	sources := 0
+	for _, source := range []string{e.SolidityFile, e.ArtifactFile, e.ABIFile} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of a Solidity/Vyper file, a contract artifact or an ABI must be specified")
+	}
+	if e.ABIFile != "" && e.Contract == "" {
+		return fmt.Errorf("a pre-deployed contract address must be specified when using an ABI")
+	}
+	return nil
+}
+
+// loadContract compiles or loads the contract, and prepares the methods to exercise
+func (e *Exerciser) loadContract() (compiled *CompiledSolidity, err error) {
+
+	if err = e.checkSources(); err != nil {
+		return nil, err
+	}
+
+	// A single method is exercised, unless a workload mix declares several with weights
+	var mix []*MixEntry
+	var args []interface{}
+	exercising := fmt.Sprintf("method '%s'", e.Method)
+	if e.Mix != "" {
+		if e.Method != "" || len(e.Args) > 0 || e.ArgsFile != "" {
+			return nil, fmt.Errorf("a method and args cannot be specified with a workload mix")
+		}
+		if mix, err = LoadMix(e.Mix); err != nil {
+			return nil, err
+		}
+		exercising = fmt.Sprintf("workload mix %s", e.Mix)
+	} else if e.Method == "" {
+		return nil, fmt.Errorf("a method or a workload mix must be specified")
+	} else {
+		if args, err = e.methodArgs(); err != nil {
+			return nil, err
+		}
+		mix = []*MixEntry{{Method: e.Method, Weight: 1, Args: args, Call: &e.Call}}
+		if hasArgTemplates(args) {
+			// Evaluated for each transaction. A sample is used to check the templates and types up front
+			if args, err = expandArgTemplates(args, &argTemplateContext{rand: rand.New(rand.NewSource(0))}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if compiled, err = e.compileContract(exercising, args); err != nil {
+		return nil, err
+	}
+
+	if e.ExpectEvent != "" {
+		if e.Call {
+			return nil, fmt.Errorf("an expected event cannot be specified for calls")
+		}
+		if e.expectedEvent, err = FindEvent(compiled.ABI, e.ExpectEvent); err != nil {
+			return nil, err
+		}
+	}
+	if err = e.initWorkload(compiled.ABI, mix); err != nil {
+		return nil, err
+	}
+
+	if e.Contract == "" {
+		if err = e.prepareDeploy(compiled); err != nil {
+			return nil, err
+		}
+	} else if len(e.ConstructorArgs) > 0 || len(e.Links) > 0 {
+		return nil, fmt.Errorf("constructor args and library links cannot be specified for a pre-deployed contract")
+	}
+	return compiled, nil
+}
+
+// compileContract compiles or loads the contract from the source specified, packing
+// the call to the method if there is one
+func (e *Exerciser) compileContract(exercising string, args []interface{}) (compiled *CompiledSolidity, err error) {
+	if e.ABIFile != "" {
+		log.Debug("Loading ABI ", e.ABIFile)
+		if compiled, err = LoadABI(e.ABIFile, e.Method, args); err != nil {
+			return nil, err
+		}
+		log.Info("Exercising ", exercising, " in contract ABI ", e.ABIFile)
+	} else if e.ArtifactFile != "" {
+		log.Debug("Loading contract artifact ", e.ArtifactFile)
+		if compiled, err = LoadArtifact(e.ArtifactFile, e.ContractName, e.Method, args); err != nil {
+			return nil, err
+		}
+		log.Info("Exercising ", exercising, " in contract artifact ", e.ArtifactFile)
+	} else {
+		log.Debug("Compiling contract source ", e.SolidityFile)
+		if compiled, err = CompileContract(e.SolidityFile, e.compilerOptions(), e.ContractName, e.Method, args); err != nil {
+			return nil, err
+		}
+		log.Info("Exercising ", exercising, " in contract ", e.SolidityFile)
+	}
+	return compiled, nil
+}
+
+// prepareDeploy packs the constructor args and parses the library links for deployment
+func (e *Exerciser) prepareDeploy(compiled *CompiledSolidity) (err error) {
+	if err = compiled.PackConstructor(toInterfaceArgs(e.ConstructorArgs)); err != nil {
+		return err
+	}
+	e.libraryLinks, err = compiled.ParseLibraryLinks(e.Links)
+	return err
+}
+
+// connect checks the accounts and generates any keys needed, then connects to the nodes
+func (e *Exerciser) connect() (keys []*ecdsa.PrivateKey, endpoints *endpointPool, err error) {
+
+	if len(e.URLs) == 0 {
+		return nil, nil, fmt.Errorf("at least one node URL must be specified")
+	}
+	if e.TLSInsecure {
+		log.Warn("TLS certificate verification is disabled. Only use --tls-insecure with test environments")
+	}
+
+	if !e.ExternalSign && (e.KeystoreDir != "" || e.KeystorePassFile != "" || e.KeystorePassEnv != "" || e.KeystoreLight) {
+		return nil, nil, fmt.Errorf("a keystore can only be used with external signing")
+	}
+	if e.KeystoreDir == "" && (e.KeystorePassFile != "" || e.KeystorePassEnv != "" || e.KeystoreLight) {
+		return nil, nil, fmt.Errorf("a password and light scrypt parameters can only be specified for a keystore directory")
+	}
+
 	if !e.ExternalSign && len(e.Accounts) < e.Workers {
-		return fmt.Errorf("need accounts for each of %d workers (%d supplied)", e.Workers, len(e.Accounts))
+		return nil, nil, fmt.Errorf("need accounts for each of %d workers (%d supplied)", e.Workers, len(e.Accounts))
 	}
 
-	var keys []*ecdsa.PrivateKey
 	if e.ExternalSign {
 		if keys, err = e.ensurePrivateKeys(); err != nil {
-			return err
+			return nil, nil, err
 		}
 	}
 
@@ -174,57 +474,35 @@ func (e *Exerciser) Start() (err error) {
 		if e.metrics, err = statsd.New(
 			statsd.Address(e.StatsdServer),
 			statsd.FlushPeriod(time.Duration(e.StatsdFlushPeriod)*time.Millisecond)); err != nil {
-			return fmt.Errorf("failed to create metrics sink to statsd %s: %s", e.StatsdServer, err)
+			return nil, nil, fmt.Errorf("failed to create metrics sink to statsd %s: %s", e.StatsdServer, err)
 		}
 	}
 
-	log.Debug("Compiling solidity file ", e.SolidityFile)
-	compiled, err := CompileContract(e.SolidityFile, e.EVMVersion, e.ContractName, e.Method, e.Args)
-	if err != nil {
-		return err
-	}
-	log.Info("Exercising method '", e.Method, "' in solidity contract ", e.SolidityFile)
-
 	if e.PrivateFrom != "" {
 		if e.ExternalSign {
-			return fmt.Errorf("external signing not currently supported with private transactions")
+			return nil, nil, fmt.Errorf("external signing not currently supported with private transactions")
 		}
 		log.Debug("PrivateFrom='", e.PrivateFrom, "' PrivateFor='", e.PrivateFor, "'")
 	}
 
 	if e.ExternalSign && e.ChainID <= 0 {
 		if e.ChainID, err = e.GetNetworkID(); err != nil {
-			return err
+			return nil, nil, err
 		}
 		log.Debug("ChainID=", e.ChainID)
 	}
 
 	log.Debug("Connecting workers. Count=", e.Workers)
 
-	// Connect the client
-	dialer := &net.Dialer{
-		Timeout:   time.Duration(60) * time.Second,
-		KeepAlive: time.Duration(60) * time.Second,
-		DualStack: true,
-	}
-	transport := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           dialer.DialContext,
-		MaxIdleConns:          500,
-		MaxIdleConnsPerHost:   500,
-		MaxConnsPerHost:       500,
-		IdleConnTimeout:       time.Duration(100) * time.Second,
-		ExpectContinueTimeout: 0, // Send body immediately on the back-end
-	}
-	httpClient := &http.Client{
-		Transport: transport,
-	}
-	rpcClient, err := rpc.DialHTTPWithClient(e.URL, httpClient)
-	if err != nil {
-		return fmt.Errorf("connection to %s failed: %s", e.URL, err)
+	// Connect the clients
+	if endpoints, err = e.dialEndpoints(); err != nil {
+		return nil, nil, err
 	}
-	log.Debug("Connected. URL=", e.URL)
+	return keys, endpoints, nil
+}
 
+// newWorkers initializes a worker for each account
+func (e *Exerciser) newWorkers(endpoints *endpointPool, compiled *CompiledSolidity, keys []*ecdsa.PrivateKey) ([]Worker, error) {
 	var workers = make([]Worker, e.Workers)
 	for i := 0; i < len(workers); i++ {
 		worker := &workers[i]
@@ -235,36 +513,30 @@ func (e *Exerciser) Start() (err error) {
 		if e.ExternalSign {
 			worker.PrivateKey = keys[i]
 		}
-		if err := worker.Init(rpcClient); err != nil {
-			return err
+		if err := worker.Init(endpoints); err != nil {
+			return nil, err
 		}
 	}
+	return workers, nil
+}
 
-	if e.Contract == "" {
-		var deployed = false
-		for i := 0; i < e.Workers && !deployed; i++ {
-			contractWorker := &workers[i]
-
-			log.Infof("Deploying contract using worker %s", contractWorker.Name)
-			e.To, err = contractWorker.InstallContract()
-			if err == nil {
-				deployed = true
-			} else {
-				log.Errorf("Failed to deploy contract using work %s: %s", contractWorker.Name, err)
-			}
-		}
-		if !deployed {
-			return err
-		}
-	} else {
-		if !common.IsHexAddress(e.Contract) {
-			return fmt.Errorf("invalid contract address: %s", e.Contract)
+// deployContract deploys the contract, trying each worker in turn
+func (e *Exerciser) deployContract(workers []Worker) (addr *common.Address, err error) {
+	for i := 0; i < len(workers); i++ {
+		contractWorker := &workers[i]
+
+		log.Infof("Deploying contract using worker %s", contractWorker.Name)
+		addr, err = contractWorker.InstallContract()
+		if err == nil {
+			return addr, nil
 		}
-		contractAddr := common.HexToAddress(e.Contract)
-		e.To = &contractAddr
+		log.Errorf("Failed to deploy contract using work %s: %s", contractWorker.Name, err)
 	}
-	log.Info("Contract address=", e.To.Hex())
+	return nil, err
+}
 
+// runWorkers runs the workload on all the workers, and waits for them to complete
+func (e *Exerciser) runWorkers(workers []Worker) error {
 	if e.EstimateGas {
 		log.Debug("Calling contract")
 		if err := workers[0].CallOnce(); err != nil {
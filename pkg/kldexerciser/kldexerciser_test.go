@@ -0,0 +1,186 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const testOrdersABI = `[
+	{"type": "function", "name": "place", "stateMutability": "nonpayable", "inputs": [
+		{"name": "order", "type": "tuple", "components": [
+			{"name": "owner", "type": "address"},
+			{"name": "amount", "type": "uint256"},
+			{"name": "lines", "type": "tuple[]", "components": [
+				{"name": "sku", "type": "string"}, {"name": "qty", "type": "uint16"}
+			]}
+		]},
+		{"name": "ids", "type": "uint64[2]"},
+		{"name": "memo", "type": "string"}
+	], "outputs": []}
+]`
+
+func TestMethodArgs(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := writeTestFile(t, dir, "args.json", `[{"owner": "0x0102030405060708090a0b0c0d0e0f1011121314", "amount": 100000000000000000000}, [1, 2], "memo"]`, 0600)
+	args, err := (&Exerciser{ArgsFile: argsFile}).methodArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The values are passed on as parsed, with exact numbers
+	order := args[0].(map[string]interface{})
+	if len(args) != 3 || order["amount"] != json.Number("100000000000000000000") || args[2] != "memo" {
+		t.Errorf("unexpected args %#v", args)
+	}
+	if args, err := (&Exerciser{Args: []string{"1", "[1,2]"}}).methodArgs(); err != nil || !reflect.DeepEqual(args, []interface{}{"1", "[1,2]"}) {
+		t.Errorf("unexpected args %#v: %v", args, err)
+	}
+
+	notArray := writeTestFile(t, dir, "object.json", `{"args": []}`, 0600)
+	invalid := writeTestFile(t, dir, "invalid.json", `[1,`, 0600)
+	tests := []struct {
+		name string
+		e    *Exerciser
+		err  string
+	}{
+		{"both", &Exerciser{ArgsFile: argsFile, Args: []string{"1"}}, "args cannot be specified on the command line and in an args file"},
+		{"missing", &Exerciser{ArgsFile: dir + "/missing.json"}, "unable to read args file"},
+		{"invalid", &Exerciser{ArgsFile: invalid}, "unable to parse args file " + invalid},
+		{"not an array", &Exerciser{ArgsFile: notArray}, "args file " + notArray + " must contain a JSON array of arguments"},
+	}
+	for _, test := range tests {
+		if _, err := test.e.methodArgs(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing '%s', got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestNestedArgsRoundTrip(t *testing.T) {
+	contractABI, err := abi.JSON(strings.NewReader(testOrdersABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := "0x0102030405060708090a0b0c0d0e0f1011121314"
+	order := `{"owner": "` + owner + `", "amount": "100000000000000000000", "lines": [{"sku": "apple", "qty": 2}, ["pear", "1"]]}`
+	argsFile := writeTestFile(t, t.TempDir(), "args.json", `[`+order+`, [1, "0x02"], "memo"]`, 0600)
+	fileArgs, err := (&Exerciser{ArgsFile: argsFile}).methodArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same values from the command line, as JSON strings, or from the args file
+	for name, args := range map[string][]interface{}{
+		"command line": {order, `[1, "0x02"]`, "memo"},
+		"args file":    fileArgs,
+	} {
+		typedArgs, err := GenerateTypedArgs(contractABI, "place", args)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		method := contractABI.Methods["place"]
+		packed, err := method.Inputs.Pack(typedArgs...)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		unpacked, err := method.Inputs.Unpack(packed)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		o := reflect.ValueOf(unpacked[0])
+		if o.Field(0).Interface().(common.Address) != common.HexToAddress(owner) || o.Field(1).Interface().(*big.Int).String() != "100000000000000000000" {
+			t.Errorf("%s: unexpected order %+v", name, unpacked[0])
+		}
+		lines := o.Field(2)
+		if lines.Len() != 2 || lines.Index(1).Field(0).String() != "pear" || lines.Index(1).Field(1).Uint() != 1 {
+			t.Errorf("%s: unexpected lines %+v", name, lines.Interface())
+		}
+		if unpacked[1].([2]uint64) != [2]uint64{1, 2} || unpacked[2].(string) != "memo" {
+			t.Errorf("%s: unexpected args %v", name, unpacked[1:])
+		}
+	}
+
+	// Errors give the path to the value in the nested tuples
+	badLines := `{"owner": "` + owner + `", "amount": 1, "lines": [{"sku": "apple", "qty": 70000}]}`
+	if _, err := GenerateTypedArgs(contractABI, "place", []interface{}{badLines, "[1, 2]", ""}); err == nil ||
+		!strings.Contains(err.Error(), "invalid value for arg 0 (order)") || !strings.Contains(err.Error(), ".lines: ") || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStartWithABI(t *testing.T) {
+	abiFile := writeTestFile(t, t.TempDir(), "Store.json", `[
+		{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "x", "type": "uint256"}], "outputs": []},
+		{"type": "function", "name": "get", "stateMutability": "view", "inputs": [], "outputs": [{"name": "", "type": "uint256"}]}
+	]`, 0600)
+	contract := "0x0102030405060708090a0b0c0d0e0f1011121314"
+	tests := []struct {
+		name   string
+		method string
+		set    func(e *Exerciser)
+		rpc    string
+	}{
+		{"transaction", "set", func(e *Exerciser) {}, "eth_sendTransaction"},
+		{"call", "get", func(e *Exerciser) { e.Call = true }, "eth_call"},
+		{"estimate gas", "set", func(e *Exerciser) { e.EstimateGas = true }, "eth_estimateGas"},
+	}
+	for _, test := range tests {
+		node := newTestNode(t)
+		node.callData = make([]byte, 32)
+		e := newTestExerciser(node)
+		e.ABIFile = abiFile
+		e.Contract = contract
+		e.Method = test.method
+		if test.method == "set" {
+			e.Args = []string{"42"}
+		}
+		test.set(e)
+		if err := e.Start(); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		// Nothing is deployed, and the method is invoked on the contract
+		if node.requestCount(test.rpc) != 1 || len(node.sent) > 1 || (len(node.sent) == 1 && len(node.sent[0]) != 4+32) {
+			t.Errorf("%s: unexpected requests %v, sent %v", test.name, node.requests, node.sent)
+		}
+		if e.To == nil || *e.To != common.HexToAddress(contract) {
+			t.Errorf("%s: unexpected contract address %v", test.name, e.To)
+		}
+	}
+
+	errorTests := []struct {
+		name string
+		set  func(e *Exerciser)
+		err  string
+	}{
+		{"no contract", func(e *Exerciser) { e.Contract = "" }, "a pre-deployed contract address must be specified when using an ABI"},
+		{"constructor args", func(e *Exerciser) { e.ConstructorArgs = []string{"1"} }, "constructor args and library links cannot be specified for a pre-deployed contract"},
+		{"solidity file", func(e *Exerciser) { e.SolidityFile = "Store.sol" }, "exactly one of a Solidity/Vyper file, a contract artifact or an ABI must be specified"},
+	}
+	for _, test := range errorTests {
+		e := newTestExerciser(newTestNode(t))
+		e.ABIFile = abiFile
+		e.Contract = contract
+		e.Method = "get"
+		test.set(e)
+		if err := e.Start(); err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error '%s', got %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExportedArtifact is the compiled contract written by the compile command for other
+// tools. It uses the Truffle layout, so can be loaded again with --artifact
+type ExportedArtifact struct {
+	ContractName        string                            `json:"contractName"`
+	SourceName          string                            `json:"sourceName"`
+	Compiler            ExportedCompiler                  `json:"compiler"`
+	ABI                 interface{}                       `json:"abi"`
+	Bytecode            string                            `json:"bytecode"`
+	DeployedBytecode    string                            `json:"deployedBytecode"`
+	DeployedSourceMap   string                            `json:"deployedSourceMap,omitempty"`
+	LinkReferences      map[string]map[string][]CodeRange `json:"linkReferences"`
+	ImmutableReferences []CodeRange                       `json:"immutableReferences"`
+	MethodIdentifiers   map[string]string                 `json:"methodIdentifiers"`
+	ConstructorArgs     string                            `json:"constructorArgs,omitempty"`
+	Method              string                            `json:"method,omitempty"`
+	Calldata            string                            `json:"calldata,omitempty"`
+}
+
+// ExportedCompiler is the compiler that built the exported artifact
+type ExportedCompiler struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// NewExportedArtifact builds the artifact for a compiled contract, including the
+// selectors of all methods, and the packed calldata for the method if there is one
+func NewExportedArtifact(c *CompiledSolidity, sourceName string) *ExportedArtifact {
+	artifact := &ExportedArtifact{
+		ContractName:        c.Name[strings.LastIndex(c.Name, ":")+1:],
+		SourceName:          sourceName,
+		Compiler:            ExportedCompiler{Name: "solc", Version: c.ContractInfo.CompilerVersion},
+		ABI:                 c.ContractInfo.AbiDefinition,
+		Bytecode:            c.Compiled,
+		DeployedBytecode:    c.RuntimeCode,
+		DeployedSourceMap:   c.ContractInfo.SrcMapRuntime,
+		LinkReferences:      exportLinkReferences(c),
+		ImmutableReferences: c.Immutables,
+		MethodIdentifiers:   make(map[string]string),
+	}
+	if c.ContractInfo.Language == "Vyper" {
+		artifact.Compiler.Name = "vyper"
+	}
+	for _, method := range c.ABI.Methods {
+		artifact.MethodIdentifiers[method.Sig] = hex.EncodeToString(method.ID)
+	}
+	if len(c.PackedConstruct) > 0 {
+		artifact.ConstructorArgs = hexutil.Encode(c.PackedConstruct)
+	}
+	if c.Method != nil {
+		artifact.Method = c.Method.Sig
+		artifact.Calldata = hexutil.Encode(c.PackedCall)
+	}
+	return artifact
+}
+
+// exportLinkReferences records the placeholders of the libraries in the bytecode, keyed by
+// file then library name, so the libraries can be linked by name when it is loaded again
+func exportLinkReferences(c *CompiledSolidity) map[string]map[string][]CodeRange {
+	refs := make(map[string]map[string][]CodeRange)
+	for _, fullName := range requiredLibraries(c.Compiled, c.libraryNames()) {
+		file, name := "", fullName
+		if idx := strings.LastIndex(fullName, ":"); idx >= 0 {
+			file, name = fullName[:idx], fullName[idx+1:]
+		}
+		if refs[file] == nil {
+			refs[file] = make(map[string][]CodeRange)
+		}
+		refs[file][name] = placeholderRanges(c.Compiled, fullName)
+	}
+	return refs
+}
+
+// Export compiles the contract without connecting to a node, and writes the
+// artifact to the export file, or stdout
+func (e *Exerciser) Export() error {
+	if e.SolidityFile == "" {
+		return fmt.Errorf("a Solidity/Vyper file must be specified to compile")
+	}
+	args, err := e.methodArgs()
+	if err != nil {
+		return err
+	}
+	if e.Method == "" && len(args) > 0 {
+		return fmt.Errorf("args cannot be specified without a method")
+	}
+
+	log.Debug("Compiling contract source ", e.SolidityFile)
+	compiled, err := CompileContract(e.SolidityFile, e.compilerOptions(), e.ContractName, e.Method, args)
+	if err != nil {
+		return err
+	}
+	if len(e.ConstructorArgs) > 0 {
+		if err = compiled.PackConstructor(toInterfaceArgs(e.ConstructorArgs)); err != nil {
+			return err
+		}
+	}
+
+	artifactJSON, err := json.MarshalIndent(NewExportedArtifact(compiled, e.SolidityFile), "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing artifact: %s", err)
+	}
+	artifactJSON = append(artifactJSON, '\n')
+	if e.ExportFile == "" || e.ExportFile == "-" {
+		_, err = os.Stdout.Write(artifactJSON)
+		return err
+	}
+	if err = ioutil.WriteFile(e.ExportFile, artifactJSON, 0644); err != nil {
+		return fmt.Errorf("unable to write artifact %s: %s", e.ExportFile, err)
+	}
+	log.Info("Wrote artifact for contract ", compiled.Name, " to ", e.ExportFile)
+	return nil
+}
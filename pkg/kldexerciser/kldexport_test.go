@@ -0,0 +1,138 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/compiler"
+)
+
+// exportAndLoad writes the exported artifact for a contract, and loads it again with --artifact
+func exportAndLoad(t *testing.T, c *CompiledSolidity) *CompiledSolidity {
+	artifactJSON, err := json.Marshal(NewExportedArtifact(c, "contracts/Store.sol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactFile := writeTestFile(t, t.TempDir(), "Store.json", string(artifactJSON), 0644)
+	loaded, err := LoadArtifact(artifactFile, "Store", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func testExportContract(language string, immutables []CodeRange) *CompiledSolidity {
+	runtimeCode := "0x" + hex.EncodeToString(testImmutableCode(0))
+	return &CompiledSolidity{
+		Name:        "contracts/Store.sol:Store",
+		Compiled:    "0x6080",
+		RuntimeCode: runtimeCode,
+		Immutables:  immutables,
+		ContractInfo: compiler.ContractInfo{
+			Language:        language,
+			CompilerVersion: "0.8.19+commit.7dd6d404",
+			AbiDefinition:   []interface{}{},
+		},
+	}
+}
+
+func TestExportedArtifactImmutablesRoundTrip(t *testing.T) {
+	immutables := []CodeRange{{Start: 3, Length: 32}}
+	loaded := exportAndLoad(t, testExportContract("Solidity", immutables))
+	if !reflect.DeepEqual(loaded.Immutables, immutables) {
+		t.Errorf("expected immutables %v, got %v", immutables, loaded.Immutables)
+	}
+	if loaded.Name != "Store" || loaded.RuntimeCode != "0x"+hex.EncodeToString(testImmutableCode(0)) {
+		t.Errorf("unexpected contract %s %s", loaded.Name, loaded.RuntimeCode)
+	}
+	// The immutable is ignored when verifying the deployed code, as it is for the compiled contract
+	if err := loaded.VerifyRuntimeCode(testImmutableCode(42)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// A contract without immutables is known to have none
+	loaded = exportAndLoad(t, testExportContract("Solidity", []CodeRange{}))
+	if loaded.Immutables == nil || len(loaded.Immutables) != 0 || loaded.immutablesUnknown() {
+		t.Errorf("expected no immutables, got %v", loaded.Immutables)
+	}
+	if err := loaded.VerifyRuntimeCode(testImmutableCode(42)); err == nil {
+		t.Errorf("expected a difference in the code")
+	}
+}
+
+func TestExportedVyperArtifactRoundTrip(t *testing.T) {
+	loaded := exportAndLoad(t, testExportContract("Vyper", nil))
+	if loaded.ContractInfo.Language != "Vyper" || loaded.immutablesUnknown() {
+		t.Errorf("unexpected language %s", loaded.ContractInfo.Language)
+	}
+}
+
+func TestTruffleArtifactImmutablesUnknown(t *testing.T) {
+	artifactFile := writeTestFile(t, t.TempDir(), "Store.json",
+		`{"contractName": "Store", "abi": [], "bytecode": "0x6080", "deployedBytecode": "0x6080"}`, 0644)
+	loaded, err := LoadArtifact(artifactFile, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.immutablesUnknown() {
+		t.Errorf("expected unknown immutables, got %v", loaded.Immutables)
+	}
+}
+
+func TestExportedArtifactLinkReferences(t *testing.T) {
+	c := testExportContract("Solidity", []CodeRange{})
+	c.Compiled = "0x6080" + "73" + mathPlaceholder + "6000" + "73" + mathPlaceholder + "f3"
+	c.Libraries = map[string]string{"libraries/math.sol:Math": "0x6080", "contracts/Store.sol:Store": c.Compiled}
+	artifact := NewExportedArtifact(c, "contracts/Store.sol")
+	expected := map[string]map[string][]CodeRange{
+		"libraries/math.sol": {"Math": {{Start: 3, Length: 20}, {Start: 26, Length: 20}}},
+	}
+	if !reflect.DeepEqual(artifact.LinkReferences, expected) {
+		t.Errorf("expected link references %v, got %v", expected, artifact.LinkReferences)
+	}
+
+	// The library can be linked by its name alone after the artifact is loaded again
+	loaded := exportAndLoad(t, c)
+	links, err := loaded.ParseLibraryLinks([]string{"Math=0x00000000000000000000000000000000000000aa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := links["libraries/math.sol:Math"]; !ok {
+		t.Fatalf("library name not resolved: %v", links)
+	}
+	if linked := linkBytecode(loaded.Compiled, links); isUnlinked(linked) {
+		t.Errorf("still unlinked: %s", linked)
+	}
+
+	// Without libraries the link references are empty
+	if refs := NewExportedArtifact(testExportContract("Solidity", nil), "contracts/Store.sol").LinkReferences; len(refs) != 0 {
+		t.Errorf("unexpected link references %v", refs)
+	}
+}
+
+func TestPlaceholderRanges(t *testing.T) {
+	code := "0x60" + legacyPlaceholder + "60" + mathPlaceholder
+	expected := []CodeRange{{Start: 1, Length: 20}, {Start: 22, Length: 20}}
+	if ranges := placeholderRanges(code, "libraries/math.sol:Math"); !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("expected %v, got %v", expected, ranges)
+	}
+	if ranges := placeholderRanges(code, "contracts/Lib.sol:Lib"); ranges != nil {
+		t.Errorf("unexpected ranges %v", ranges)
+	}
+}
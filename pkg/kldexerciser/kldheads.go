@@ -0,0 +1,85 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// newHead is the part of the block header we use from the newHeads subscription
+type newHead struct {
+	Number *hexutil.Big `json:"number"`
+	Hash   common.Hash  `json:"hash"`
+}
+
+// subscribeNewHeads subscribes to new blocks over WebSocket and IPC connections, before
+// sending transactions, so receipts are checked as each block arrives instead of polling
+func (w *Worker) subscribeNewHeads() {
+	ep := w.endpoints.pick(w.Index)
+	if !ep.Streaming {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.Exerciser.RPCTimeout)*time.Second)
+	defer cancel()
+	heads := make(chan *newHead, 16)
+	sub, err := ep.RPC.EthSubscribe(ctx, heads, "newHeads")
+	if err != nil {
+		w.error("Failed to subscribe to new blocks from %s, polling for receipts instead: %s", ep.Name, err)
+		return
+	}
+	w.heads = heads
+	w.headSub = sub
+}
+
+// unsubscribeNewHeads ends the subscription, once the receipts have been received
+func (w *Worker) unsubscribeNewHeads() {
+	if w.headSub != nil {
+		w.headSub.Unsubscribe()
+		w.headSub = nil
+		w.heads = nil
+	}
+}
+
+// waitForBlock waits up to the timeout for a new block when subscribed, and
+// otherwise sleeps for the retry delay before the receipt is polled again
+func (w *Worker) waitForBlock(retryDelay, timeout time.Duration) {
+	if w.headSub == nil {
+		time.Sleep(retryDelay)
+		return
+	}
+	select {
+	case head := <-w.heads:
+		w.debug("New block %s %s", head.Number.ToInt(), head.Hash.Hex())
+	case err := <-w.headSub.Err():
+		w.error("Subscription to new blocks failed, polling for receipts instead: %s", err)
+		w.unsubscribeNewHeads()
+	case <-time.After(timeout):
+	}
+}
+
+// waitForMining waits before the first receipt check: for a new block when subscribed,
+// and otherwise for the minimum time configured
+func (w *Worker) waitForMining(minWait time.Duration) {
+	if w.headSub == nil {
+		time.Sleep(minWait)
+		return
+	}
+	w.debug("Waiting for a new block")
+	w.waitForBlock(0, time.Duration(w.Exerciser.ReceiptWaitMax)*time.Second)
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	ecrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// keystorePassword reads the password for the keystore files from the password file,
+// or the environment variable
+func (e *Exerciser) keystorePassword() (string, error) {
+	switch {
+	case e.KeystorePassFile != "" && e.KeystorePassEnv != "":
+		return "", fmt.Errorf("only one of a password file or password environment variable can be specified for the keystore")
+	case e.KeystorePassFile != "":
+		password, err := ioutil.ReadFile(e.KeystorePassFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read keystore password file %s: %s", e.KeystorePassFile, err)
+		}
+		// Only the line ending is removed, as spaces are valid in a password
+		return strings.TrimRight(string(password), "\r\n"), nil
+	case e.KeystorePassEnv != "":
+		password, ok := os.LookupEnv(e.KeystorePassEnv)
+		if !ok {
+			return "", fmt.Errorf("keystore password environment variable %s is not set", e.KeystorePassEnv)
+		}
+		return password, nil
+	}
+	return "", fmt.Errorf("a password file or password environment variable must be specified for keystore %s", e.KeystoreDir)
+}
+
+// isKeystoreV3 checks the file is keystore V3 JSON, with an encrypted key
+func isKeystoreV3(keyJSON []byte) bool {
+	var header struct {
+		Version int             `json:"version"`
+		Crypto  json.RawMessage `json:"crypto"`
+	}
+	return json.Unmarshal(keyJSON, &header) == nil && header.Version == 3 && len(header.Crypto) > 0
+}
+
+// loadKeystore decrypts the keystore V3 files in the keystore directory, in file name order.
+// Other files are skipped. The directory is created if it does not exist
+func (e *Exerciser) loadKeystore(password string) ([]*ecdsa.PrivateKey, error) {
+	if err := os.MkdirAll(e.KeystoreDir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create keystore directory %s: %s", e.KeystoreDir, err)
+	}
+	files, err := ioutil.ReadDir(e.KeystoreDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read keystore directory %s: %s", e.KeystoreDir, err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	keys := []*ecdsa.PrivateKey{}
+	for _, file := range files {
+		// Skip directories, and editor/hidden files, as geth does
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || strings.HasSuffix(file.Name(), "~") {
+			continue
+		}
+		path := filepath.Join(e.KeystoreDir, file.Name())
+		keyJSON, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read keystore file %s: %s", path, err)
+		}
+		if !isKeystoreV3(keyJSON) {
+			log.Debugf("Skipping %s in keystore directory, as it is not a keystore V3 file", path)
+			continue
+		}
+		key, err := keystore.DecryptKey(keyJSON, password)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt keystore file %s: %s", path, err)
+		}
+		log.Debugf("Loaded key for %s from %s", key.Address.Hex(), path)
+		keys = append(keys, key.PrivateKey)
+	}
+	return keys, nil
+}
+
+// storeKeystore encrypts a key into a new keystore V3 file, named as geth names them,
+// readable only by the current user
+func (e *Exerciser) storeKeystore(privateKey *ecdsa.PrivateKey, password string) error {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("generating key ID: %s", err)
+	}
+	key := &keystore.Key{
+		Id:         id,
+		Address:    ecrypto.PubkeyToAddress(privateKey.PublicKey),
+		PrivateKey: privateKey,
+	}
+	scryptN, scryptP := keystore.StandardScryptN, keystore.StandardScryptP
+	if e.KeystoreLight {
+		scryptN, scryptP = keystore.LightScryptN, keystore.LightScryptP
+	}
+	keyJSON, err := keystore.EncryptKey(key, password, scryptN, scryptP)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt key for %s: %s", key.Address.Hex(), err)
+	}
+	ts := time.Now().UTC().Format("2006-01-02T15-04-05.000000000Z")
+	path := filepath.Join(e.KeystoreDir, fmt.Sprintf("UTC--%s--%x", ts, key.Address))
+	if err = ioutil.WriteFile(path, keyJSON, 0600); err != nil {
+		return fmt.Errorf("unable to write keystore file %s: %s", path, err)
+	}
+	log.Infof("Generated key for %s in %s", key.Address.Hex(), path)
+	return nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	ecrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+func newKeystoreExerciser(keystoreDir string, workers int) *Exerciser {
+	return &Exerciser{
+		ExternalSign:    true,
+		KeystoreDir:     keystoreDir,
+		KeystorePassEnv: "KLD_TEST_KEYSTORE_PASSWORD",
+		KeystoreLight:   true,
+		Workers:         workers,
+	}
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	t.Setenv("KLD_TEST_KEYSTORE_PASSWORD", " pass word ")
+	keystoreDir := filepath.Join(t.TempDir(), "keystore")
+	keys, err := newKeystoreExerciser(keystoreDir, 2).ensurePrivateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	files, _ := ioutil.ReadDir(keystoreDir)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 keystore files, got %d", len(files))
+	}
+	for _, file := range files {
+		if file.Mode().Perm() != 0600 {
+			t.Errorf("%s has mode %s", file.Name(), file.Mode())
+		}
+		keyJSON, _ := ioutil.ReadFile(filepath.Join(keystoreDir, file.Name()))
+		var encrypted struct {
+			Crypto keystore.CryptoJSON `json:"crypto"`
+		}
+		if err := json.Unmarshal(keyJSON, &encrypted); err != nil {
+			t.Fatal(err)
+		}
+		if n := encrypted.Crypto.KDFParams["n"]; n != float64(keystore.LightScryptN) {
+			t.Errorf("%s: expected light scrypt N=%d, got %v", file.Name(), keystore.LightScryptN, n)
+		}
+		if !strings.HasPrefix(file.Name(), "UTC--") {
+			t.Errorf("unexpected file name %s", file.Name())
+		}
+	}
+	// Editor and hidden files are skipped, as are files that are not keystore V3 JSON
+	writeTestFile(t, keystoreDir, ".DS_Store", "", 0600)
+	writeTestFile(t, keystoreDir, files[0].Name()+"~", "", 0600)
+	writeTestFile(t, keystoreDir, "README", "Keys for the load test accounts\n", 0600)
+	writeTestFile(t, keystoreDir, "accounts.json", `{"accounts": ["0x0102030405060708090a0b0c0d0e0f1011121314"]}`, 0600)
+	writeTestFile(t, keystoreDir, "v1.json", `{"version": 1, "crypto": {}}`, 0600)
+
+	// The stored keys are loaded again in the same order, and a key is generated for the extra worker
+	reloaded, err := newKeystoreExerciser(keystoreDir, 3).ensurePrivateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded) != 3 {
+		t.Fatalf("expected 3 keys, got %d", len(reloaded))
+	}
+	for i := range keys {
+		if ecrypto.PubkeyToAddress(reloaded[i].PublicKey) != ecrypto.PubkeyToAddress(keys[i].PublicKey) {
+			t.Errorf("key %d changed when reloaded", i)
+		}
+	}
+
+	// The password must match
+	t.Setenv("KLD_TEST_KEYSTORE_PASSWORD", "pass word")
+	if _, err := newKeystoreExerciser(keystoreDir, 1).ensurePrivateKeys(); err == nil || !strings.Contains(err.Error(), "unable to decrypt keystore file") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestIsKeystoreV3(t *testing.T) {
+	tests := map[string]bool{
+		`{"version": 3, "crypto": {"cipher": "aes-128-ctr"}}`: true,
+		`{"version": 3, "Crypto": {"cipher": "aes-128-ctr"}}`: true,
+		`{"version": 3}`:                 false,
+		`{"version": 1, "crypto": {}}`:   false,
+		`{"version": "3", "crypto": {}}`: false,
+		`["version", 3]`:                 false,
+		"# Keystore":                     false,
+		"":                               false,
+	}
+	for keyJSON, expected := range tests {
+		if isKeystoreV3([]byte(keyJSON)) != expected {
+			t.Errorf("%s: expected %t", keyJSON, expected)
+		}
+	}
+}
+
+func TestKeystorePassword(t *testing.T) {
+	passFile := writeTestFile(t, t.TempDir(), "password", " pass word \r\n", 0600)
+	e := &Exerciser{KeystorePassFile: passFile}
+	if password, err := e.keystorePassword(); err != nil || password != " pass word " {
+		t.Errorf("unexpected password '%s': %v", password, err)
+	}
+	e.KeystorePassEnv = "KLD_TEST_KEYSTORE_PASSWORD"
+	if _, err := e.keystorePassword(); err == nil || !strings.Contains(err.Error(), "only one of") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	e.KeystorePassFile = ""
+	os.Unsetenv("KLD_TEST_KEYSTORE_PASSWORD")
+	if _, err := e.keystorePassword(); err == nil || !strings.Contains(err.Error(), "is not set") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	e.KeystorePassEnv = ""
+	if _, err := e.keystorePassword(); err == nil || !strings.Contains(err.Error(), "must be specified") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestKeystoreRequiresExternalSigning(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(e *Exerciser)
+		err  string
+	}{
+		{"keystore", func(e *Exerciser) { e.KeystoreDir = "keystore" }, "a keystore can only be used with external signing"},
+		{"password", func(e *Exerciser) { e.KeystorePassFile = "password" }, "a keystore can only be used with external signing"},
+		{"password-env", func(e *Exerciser) { e.KeystorePassEnv = "PASSWORD" }, "a keystore can only be used with external signing"},
+		{"keystore-light", func(e *Exerciser) { e.KeystoreLight = true }, "a keystore can only be used with external signing"},
+		{"password without keystore", func(e *Exerciser) {
+			e.ExternalSign = true
+			e.KeystorePassEnv = "PASSWORD"
+		}, "can only be specified for a keystore directory"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := newTestExerciser(newTestNode(t))
+			test.set(e)
+			if _, _, err := e.connect(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error containing '%s', got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestKeysFilePermissions(t *testing.T) {
+	// An existing keys file is made readable only by the user when keys are added
+	keysFile := writeTestFile(t, t.TempDir(), "keys.json", "[]", 0644)
+	e := &Exerciser{ExternalSign: true, ExternalSignJSON: keysFile, Workers: 1}
+	keys, err := e.ensurePrivateKeys()
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("unexpected keys %v: %v", keys, err)
+	}
+	info, err := os.Stat(keysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("keys file has mode %s", info.Mode())
+	}
+	e.KeystoreDir = t.TempDir()
+	if _, err := e.ensurePrivateKeys(); err == nil || !strings.Contains(err.Error(), "only one of a keys file or keystore directory") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
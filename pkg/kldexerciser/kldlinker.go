@@ -0,0 +1,172 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	ecrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+// libraryPlaceholders returns the placeholders solc might use in unlinked bytecode
+// for a library. Since solc 0.5 this is a hash of the fully qualified name
+// (file.sol:Lib), and before that the name itself padded with underscores
+func libraryPlaceholders(fullName string) []string {
+	hash := hex.EncodeToString(ecrypto.Keccak256([]byte(fullName)))
+	placeholders := []string{"__$" + hash[:34] + "$__"}
+	names := []string{fullName}
+	if idx := strings.LastIndex(fullName, ":"); idx >= 0 {
+		names = append(names, fullName[idx+1:])
+	}
+	for _, name := range names {
+		legacy := "__" + name
+		if len(legacy) > 38 {
+			legacy = legacy[:38]
+		}
+		placeholders = append(placeholders, legacy+strings.Repeat("_", 40-len(legacy)))
+	}
+	return placeholders
+}
+
+// isUnlinked checks for any remaining placeholders, which cannot be valid hex
+func isUnlinked(code string) bool {
+	return strings.Contains(code, "__")
+}
+
+// linkBytecode substitutes the library addresses into the placeholders in the bytecode
+func linkBytecode(code string, links map[string]common.Address) string {
+	for fullName, addr := range links {
+		addrHex := hex.EncodeToString(addr.Bytes())
+		for _, placeholder := range libraryPlaceholders(fullName) {
+			code = strings.ReplaceAll(code, placeholder, addrHex)
+		}
+	}
+	return code
+}
+
+// requiredLibraries returns the names of the libraries referenced by placeholders in the bytecode
+func requiredLibraries(code string, candidates []string) []string {
+	var required []string
+	for _, fullName := range candidates {
+		for _, placeholder := range libraryPlaceholders(fullName) {
+			if strings.Contains(code, placeholder) {
+				required = append(required, fullName)
+				break
+			}
+		}
+	}
+	return required
+}
+
+// placeholderRanges returns the byte ranges of the placeholders for a library in the bytecode
+func placeholderRanges(code string, fullName string) []CodeRange {
+	code = strings.TrimPrefix(code, "0x")
+	var ranges []CodeRange
+	for _, placeholder := range libraryPlaceholders(fullName) {
+		for offset := 0; ; offset += len(placeholder) {
+			idx := strings.Index(code[offset:], placeholder)
+			if idx < 0 {
+				break
+			}
+			offset += idx
+			ranges = append(ranges, CodeRange{Start: offset / 2, Length: len(placeholder) / 2})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	return ranges
+}
+
+// libraryNames returns all the library names known from compilation or link references
+func (c *CompiledSolidity) libraryNames() []string {
+	names := append([]string{}, c.LinkReferences...)
+	for fullName := range c.Libraries {
+		if !containsString(names, fullName) {
+			names = append(names, fullName)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseLibraryLinks parses Lib=0xaddr mappings, resolving the library to its fully
+// qualified name where it is known and unique
+func (c *CompiledSolidity) ParseLibraryLinks(links []string) (map[string]common.Address, error) {
+	libraryNames := c.libraryNames()
+	parsed := make(map[string]common.Address)
+	for _, link := range links {
+		parts := strings.SplitN(link, "=", 2)
+		if len(parts) != 2 || !common.IsHexAddress(parts[1]) {
+			return nil, fmt.Errorf("invalid library link '%s' (expected Lib=0xaddr)", link)
+		}
+		name := parts[0]
+		if !strings.Contains(name, ":") {
+			var matched []string
+			for _, fullName := range libraryNames {
+				if strings.HasSuffix(fullName, ":"+name) {
+					matched = append(matched, fullName)
+				}
+			}
+			if len(matched) > 1 {
+				return nil, fmt.Errorf("library %s is ambiguous, please include the file: %s", name, matched)
+			} else if len(matched) == 1 {
+				name = matched[0]
+			}
+		}
+		parsed[name] = common.HexToAddress(parts[1])
+	}
+	return parsed, nil
+}
+
+// linkLibraries links the contract bytecode, deploying any library that does not
+// already have an address and for which we have the compiled bytecode
+func (w *Worker) linkLibraries(code string, links map[string]common.Address, deploying []string) (string, error) {
+	code = linkBytecode(code, links)
+	if !isUnlinked(code) {
+		return code, nil
+	}
+	for _, fullName := range requiredLibraries(code, w.CompiledContract.libraryNames()) {
+		if _, linked := links[fullName]; linked {
+			// Deployed as a dependency of another library
+			continue
+		}
+		libCode, ok := w.CompiledContract.Libraries[fullName]
+		if !ok {
+			return "", fmt.Errorf("bytecode for library %s not available, please supply its address to link", fullName)
+		}
+		if containsString(deploying, fullName) {
+			return "", fmt.Errorf("circular library dependency: %s", strings.Join(append(deploying, fullName), " -> "))
+		}
+		linkedLibCode, err := w.linkLibraries(libCode, links, append(append([]string{}, deploying...), fullName))
+		if err != nil {
+			return "", err
+		}
+		w.info("Deploying library %s", fullName)
+		addr, err := w.deployBytecode(common.FromHex(linkedLibCode))
+		if err != nil {
+			return "", fmt.Errorf("failed to deploy library %s: %s", fullName, err)
+		}
+		w.info("Library %s address=%s", fullName, addr.Hex())
+		links[fullName] = *addr
+	}
+	code = linkBytecode(code, links)
+	if isUnlinked(code) {
+		return "", fmt.Errorf("bytecode contains unresolved library placeholders, please supply library addresses to link: %s", w.CompiledContract.libraryNames())
+	}
+	return code, nil
+}
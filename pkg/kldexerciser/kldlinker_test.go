@@ -0,0 +1,177 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Placeholders as solc writes them in unlinked bytecode for these libraries
+const (
+	mathPlaceholder       = "__$b3367d14be1efbd813eb988c2fa78baba7$__"
+	libPlaceholder        = "__$6cf167dfb7c5c94c9fb5276b5691085b47$__"
+	legacyFullPlaceholder = "__libraries/math.sol:Math_______________"
+	legacyPlaceholder     = "__Math__________________________________"
+)
+
+func TestLibraryPlaceholders(t *testing.T) {
+	placeholders := libraryPlaceholders("libraries/math.sol:Math")
+	expected := []string{mathPlaceholder, legacyFullPlaceholder, legacyPlaceholder}
+	if strings.Join(placeholders, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected placeholders %s", placeholders)
+	}
+	for _, placeholder := range placeholders {
+		// Each placeholder takes the place of a 20 byte address
+		if len(placeholder) != 40 {
+			t.Errorf("placeholder %s is %d characters", placeholder, len(placeholder))
+		}
+	}
+	if p := libraryPlaceholders("contracts/Lib.sol:Lib")[0]; p != libPlaceholder {
+		t.Errorf("unexpected placeholder %s", p)
+	}
+	// Legacy names longer than 36 characters are truncated
+	long := libraryPlaceholders("contracts/very/long/path/to/the/Library.sol:Library")
+	if long[1] != "__contracts/very/long/path/to/the/Libr__" {
+		t.Errorf("unexpected truncated placeholder %s", long[1])
+	}
+}
+
+func TestLinkBytecode(t *testing.T) {
+	mathAddr := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	libAddr := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	code := "0x6080604052" + "73" + mathPlaceholder + "6000" + "73" + libPlaceholder + "73" + mathPlaceholder
+	if !isUnlinked(code) {
+		t.Fatal("placeholders not detected")
+	}
+	required := requiredLibraries(code, []string{"contracts/Lib.sol:Lib", "contracts/Other.sol:Other", "libraries/math.sol:Math"})
+	if strings.Join(required, ",") != "contracts/Lib.sol:Lib,libraries/math.sol:Math" {
+		t.Errorf("unexpected required libraries %s", required)
+	}
+
+	partial := linkBytecode(code, map[string]common.Address{"libraries/math.sol:Math": mathAddr})
+	if !isUnlinked(partial) || strings.Contains(partial, mathPlaceholder) {
+		t.Errorf("unexpected partial link %s", partial)
+	}
+	linked := linkBytecode(partial, map[string]common.Address{"contracts/Lib.sol:Lib": libAddr})
+	if isUnlinked(linked) {
+		t.Fatalf("still unlinked: %s", linked)
+	}
+	expected := "0x6080604052" + "73" + hex.EncodeToString(mathAddr[:]) + "6000" + "73" + hex.EncodeToString(libAddr[:]) + "73" + hex.EncodeToString(mathAddr[:])
+	if linked != expected {
+		t.Errorf("expected %s, got %s", expected, linked)
+	}
+	if _, err := hex.DecodeString(linked[2:]); err != nil {
+		t.Errorf("linked code is not valid hex: %s", err)
+	}
+
+	legacy := linkBytecode("0x73"+legacyPlaceholder, map[string]common.Address{"libraries/math.sol:Math": mathAddr})
+	if legacy != "0x73"+hex.EncodeToString(mathAddr[:]) {
+		t.Errorf("legacy placeholder not linked: %s", legacy)
+	}
+}
+
+func TestParseLibraryLinks(t *testing.T) {
+	c := &CompiledSolidity{
+		LinkReferences: []string{"libraries/math.sol:Math"},
+		Libraries: map[string]string{
+			"contracts/Lib.sol:Lib":   "0x6080",
+			"contracts/Other.sol:Lib": "0x6080",
+		},
+	}
+	links, err := c.ParseLibraryLinks([]string{"Math=0x00000000000000000000000000000000000000aa", "contracts/Lib.sol:Lib=0x00000000000000000000000000000000000000bb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if links["libraries/math.sol:Math"] != common.HexToAddress("0xaa") || links["contracts/Lib.sol:Lib"] != common.HexToAddress("0xbb") {
+		t.Errorf("unexpected links %v", links)
+	}
+	if _, err := c.ParseLibraryLinks([]string{"Lib=0x00000000000000000000000000000000000000bb"}); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("expected ambiguous error, got %v", err)
+	}
+	for _, link := range []string{"Math", "Math=0x1234", "=0x00000000000000000000000000000000000000aa="} {
+		if _, err := c.ParseLibraryLinks([]string{link}); err == nil {
+			t.Errorf("expected an error for '%s'", link)
+		}
+	}
+}
+
+func TestInstallContractDeploysLibrariesInOrder(t *testing.T) {
+	node := newTestNode(t)
+	w := newTestWorker(t, newTestExerciser(node))
+	// The contract uses Lib, which in turn uses Math. Math is deployed first, then Lib linked to it
+	mathCode := "0x60016002"
+	libCode := "0x6003" + "73" + mathPlaceholder
+	w.CompiledContract = &CompiledSolidity{
+		Compiled: "0x6004" + "73" + libPlaceholder + "73" + mathPlaceholder,
+		Libraries: map[string]string{
+			"contracts/Lib.sol:Lib":   libCode,
+			"libraries/math.sol:Math": mathCode,
+		},
+	}
+	addr, err := w.InstallContract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(node.sent) != 3 {
+		t.Fatalf("expected 3 deployments, got %d", len(node.sent))
+	}
+	mathAddr, libAddr := testContractAddress(1), testContractAddress(2)
+	expected := []string{
+		mathCode,
+		"0x6003" + "73" + hex.EncodeToString(mathAddr[:]),
+		"0x6004" + "73" + hex.EncodeToString(libAddr[:]) + "73" + hex.EncodeToString(mathAddr[:]),
+	}
+	for i := range expected {
+		if node.sent[i].String() != expected[i] {
+			t.Errorf("deployment %d: expected %s, got %s", i, expected[i], node.sent[i])
+		}
+	}
+	if *addr != testContractAddress(3) {
+		t.Errorf("unexpected contract address %s", addr.Hex())
+	}
+	// Deployed libraries are reused by later deployments
+	if w.Exerciser.libraryLinks["libraries/math.sol:Math"] != mathAddr {
+		t.Errorf("library address not recorded: %v", w.Exerciser.libraryLinks)
+	}
+}
+
+func TestLinkLibrariesErrors(t *testing.T) {
+	node := newTestNode(t)
+	w := newTestWorker(t, newTestExerciser(node))
+
+	// A library without bytecode must be supplied with --link
+	w.CompiledContract = &CompiledSolidity{LinkReferences: []string{"libraries/math.sol:Math"}}
+	if _, err := w.linkLibraries("0x73"+mathPlaceholder, map[string]common.Address{}, nil); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Libraries that depend on each other cannot be deployed
+	w.CompiledContract = &CompiledSolidity{
+		Libraries: map[string]string{
+			"contracts/Lib.sol:Lib":   "0x73" + mathPlaceholder,
+			"libraries/math.sol:Math": "0x73" + libPlaceholder,
+		},
+	}
+	if _, err := w.linkLibraries("0x73"+libPlaceholder, map[string]common.Address{}, nil); err == nil || !strings.Contains(err.Error(), "circular library dependency") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(node.sent) != 0 {
+		t.Errorf("unexpected deployments: %d", len(node.sent))
+	}
+}
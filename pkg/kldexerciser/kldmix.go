@@ -0,0 +1,155 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	log "github.com/sirupsen/logrus"
+)
+
+// MixEntry declares a method in a weighted workload mix, with its own args
+type MixEntry struct {
+	Method      string        `json:"method"`
+	Weight      int           `json:"weight"`
+	Args        []interface{} `json:"args"`
+	Call        *bool         `json:"call,omitempty"`
+	ExpectEvent string        `json:"expectEvent,omitempty"`
+}
+
+// workloadMethod is a method the workers invoke, with the call packed up front,
+// or the arg templates to evaluate for each transaction
+type workloadMethod struct {
+	Method        *abi.Method
+	PackedCall    []byte
+	ArgTemplates  []interface{}
+	Weight        int
+	Call          bool
+	ExpectedEvent *abi.Event
+}
+
+// LoadMix reads a JSON array of weighted methods from a file
+func LoadMix(mixFile string) ([]*MixEntry, error) {
+	jsonData, err := ioutil.ReadFile(mixFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read workload mix %s: %s", mixFile, err)
+	}
+	var mix []*MixEntry
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	dec.UseNumber()
+	if err := dec.Decode(&mix); err != nil {
+		return nil, fmt.Errorf("unable to parse workload mix %s: %s", mixFile, err)
+	}
+	if len(mix) == 0 {
+		return nil, fmt.Errorf("workload mix %s contains no methods", mixFile)
+	}
+	return mix, nil
+}
+
+// newWorkloadMethod resolves the method in the ABI, and packs the args or checks the
+// templates against a sample. View and pure methods in a mix are called unless specified
+func (e *Exerciser) newWorkloadMethod(contractABI abi.ABI, entry *MixEntry) (*workloadMethod, error) {
+	method, err := FindMethod(contractABI, entry.Method)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Weight <= 0 {
+		return nil, fmt.Errorf("weight for method '%s' must be greater than zero", entry.Method)
+	}
+	m := &workloadMethod{
+		Method: method,
+		Weight: entry.Weight,
+		Call:   e.Call,
+	}
+	if !e.Call {
+		if entry.Call != nil {
+			m.Call = *entry.Call
+		} else {
+			m.Call = method.IsConstant()
+		}
+	}
+	if !m.Call {
+		m.ExpectedEvent = e.expectedEvent
+	}
+	if entry.ExpectEvent != "" {
+		if m.Call {
+			return nil, fmt.Errorf("an expected event cannot be specified for method '%s', as it is called", entry.Method)
+		}
+		if m.ExpectedEvent, err = FindEvent(contractABI, entry.ExpectEvent); err != nil {
+			return nil, err
+		}
+	}
+
+	args := entry.Args
+	if hasArgTemplates(args) {
+		// Evaluated for each transaction. A sample is used to check the templates and types up front
+		m.ArgTemplates = args
+		if args, err = expandArgTemplates(args, &argTemplateContext{rand: rand.New(rand.NewSource(0))}); err != nil {
+			return nil, err
+		}
+	}
+	if m.PackedCall, err = packCall(method, args); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// initWorkload prepares the methods the workers choose between for each transaction
+func (e *Exerciser) initWorkload(contractABI abi.ABI, mix []*MixEntry) error {
+	e.methods = make([]*workloadMethod, len(mix))
+	e.totalWeight = 0
+	for i, entry := range mix {
+		m, err := e.newWorkloadMethod(contractABI, entry)
+		if err != nil {
+			return err
+		}
+		log.Debugf("Method %s weight=%d call=%t", m.Method.Sig, m.Weight, m.Call)
+		e.methods[i] = m
+		e.totalWeight += m.Weight
+	}
+	return nil
+}
+
+// pickMethod chooses the method for the next transaction according to the weights
+func (w *Worker) pickMethod() *workloadMethod {
+	methods := w.Exerciser.methods
+	if len(methods) == 1 {
+		return methods[0]
+	}
+	r := w.rand.Intn(w.Exerciser.totalWeight)
+	for _, m := range methods {
+		if r < m.Weight {
+			return m
+		}
+		r -= m.Weight
+	}
+	return methods[len(methods)-1]
+}
+
+// incrMethodCounter increments a counter broken down by method, such as method.transfer.tx.sent
+func (w *Worker) incrMethodCounter(m *workloadMethod, name string) {
+	w.incrCounter("method." + m.Method.Name + "." + name)
+}
+
+// emitMethodTiming emits a timing broken down by method, such as method.transfer.call.time
+func (w *Worker) emitMethodTiming(m *workloadMethod, name string, timing time.Duration) {
+	w.emitTiming("method."+m.Method.Name+"."+name, timing)
+}
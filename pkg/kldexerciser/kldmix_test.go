@@ -0,0 +1,159 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestLoadMix(t *testing.T) {
+	dir := t.TempDir()
+	mixFile := writeTestFile(t, dir, "mix.json", `[
+		{"method": "transfer(address,uint256)", "weight": 3, "args": ["0x0102030405060708090a0b0c0d0e0f1011121314", 100000000000000000000]},
+		{"method": "get", "weight": 1, "call": false}
+	]`, 0600)
+	mix, err := LoadMix(mixFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mix) != 2 || mix[0].Weight != 3 || mix[1].Call == nil || *mix[1].Call {
+		t.Fatalf("unexpected mix %+v", mix)
+	}
+	// Numbers are kept exact, rather than parsed as floats
+	if n, ok := mix[0].Args[1].(json.Number); !ok || n.String() != "100000000000000000000" {
+		t.Errorf("unexpected arg %#v", mix[0].Args[1])
+	}
+
+	tests := map[string]string{
+		"[]":       "contains no methods",
+		"{}":       "unable to parse workload mix",
+		"not json": "unable to parse workload mix",
+	}
+	for content, expected := range tests {
+		mixFile := writeTestFile(t, dir, "mix.json", content, 0600)
+		if _, err := LoadMix(mixFile); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing '%s', got %v", content, expected, err)
+		}
+	}
+	if _, err := LoadMix(dir + "/missing.json"); err == nil || !strings.Contains(err.Error(), "unable to read workload mix") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestInitWorkload(t *testing.T) {
+	contractABI := testOverloadsContractABI(t)
+	to := "0x0102030405060708090a0b0c0d0e0f1011121314"
+	notCalled := false
+	e := &Exerciser{}
+	err := e.initWorkload(contractABI, []*MixEntry{
+		{Method: "transfer(address)", Weight: 2, Args: []interface{}{to}},
+		{Method: "get", Weight: 1},
+		{Method: "get", Weight: 3, Call: &notCalled},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.totalWeight != 6 || len(e.methods) != 3 {
+		t.Fatalf("unexpected workload weight=%d methods=%d", e.totalWeight, len(e.methods))
+	}
+	// View methods are called unless the entry says otherwise
+	if e.methods[0].Call || !e.methods[1].Call || e.methods[2].Call {
+		t.Errorf("unexpected call settings %t %t %t", e.methods[0].Call, e.methods[1].Call, e.methods[2].Call)
+	}
+	if len(e.methods[0].PackedCall) != 4+32 {
+		t.Errorf("unexpected packed call %x", e.methods[0].PackedCall)
+	}
+
+	tests := []struct {
+		name  string
+		entry *MixEntry
+		err   string
+	}{
+		{"zero weight", &MixEntry{Method: "get", Weight: 0}, "weight for method 'get' must be greater than zero"},
+		{"overloaded", &MixEntry{Method: "transfer", Weight: 1}, "method 'transfer' is overloaded"},
+		{"bad args", &MixEntry{Method: "transfer(address)", Weight: 1}, "method requires 1 args (0 supplied)"},
+		{"event on call", &MixEntry{Method: "get", Weight: 1, ExpectEvent: "Transfer"}, "an expected event cannot be specified for method 'get'"},
+	}
+	for _, test := range tests {
+		if err := (&Exerciser{}).initWorkload(contractABI, []*MixEntry{test.entry}); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing '%s', got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestPickMethodWeighting(t *testing.T) {
+	e := &Exerciser{}
+	for _, weight := range []int{1, 3, 6} {
+		e.methods = append(e.methods, &workloadMethod{Weight: weight})
+		e.totalWeight += weight
+	}
+	w := &Worker{Exerciser: e, rand: rand.New(rand.NewSource(1))}
+	counts := make(map[*workloadMethod]int)
+	for i := 0; i < 10000; i++ {
+		counts[w.pickMethod()]++
+	}
+	for _, m := range e.methods {
+		expected := 10000 * m.Weight / e.totalWeight
+		if counts[m] < expected*9/10 || counts[m] > expected*11/10 {
+			t.Errorf("weight %d: expected around %d picks, got %d", m.Weight, expected, counts[m])
+		}
+	}
+
+	// A single method is always picked, without using the random source
+	single := &workloadMethod{Weight: 1}
+	w = &Worker{Exerciser: &Exerciser{methods: []*workloadMethod{single}, totalWeight: 1}}
+	if w.pickMethod() != single {
+		t.Errorf("single method not picked")
+	}
+}
+
+func TestRunMethodMetrics(t *testing.T) {
+	contractABI := testOverloadsContractABI(t)
+	node := newTestNode(t)
+	node.callData = make([]byte, 32)
+	e := newTestExerciser(node)
+	e.TxnsPerLoop = 20
+	metrics := newTestMetrics(t, e)
+	to := common.HexToAddress("0xc0de")
+	e.To = &to
+	if err := e.initWorkload(contractABI, []*MixEntry{
+		{Method: "transfer(address)", Weight: 1, Args: []interface{}{"0x0102030405060708090a0b0c0d0e0f1011121314"}},
+		{Method: "get", Weight: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWorker(t, e)
+	w.CompiledContract = &CompiledSolidity{ABI: contractABI}
+	w.Run()
+
+	// The counters are broken down by method name, and add up to the totals. Overloads
+	// have the names go-ethereum gives them, so transfer(address) is transfer0
+	counts := metrics.counts(t, e, w.Name)
+	sent, calls := counts["method.transfer0.tx.sent"], counts["method.get.call.success"]
+	if sent == 0 || calls == 0 || sent+calls != 20 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+	if counts["method.transfer0.tx.success"] != sent || counts["tx.success"] != sent || counts["method.get.call.fail"] != 0 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+	if len(node.sent) != sent {
+		t.Errorf("expected %d transactions, got %d", sent, len(node.sent))
+	}
+}
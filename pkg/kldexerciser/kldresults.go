@@ -0,0 +1,159 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// DecodedValue is a named value decoded from ABI encoded data
+type DecodedValue struct {
+	Name  string
+	Type  string
+	Value interface{}
+}
+
+// DecodedValues is a list of decoded values, which serializes to JSON as an
+// object with the fields in ABI order
+type DecodedValues []DecodedValue
+
+// keys are the names of the values, or their positions where a value is unnamed
+// or its name is repeated, so that no values are lost from the JSON object
+func (values DecodedValues) keys() []string {
+	keys := make([]string, len(values))
+	used := make(map[string]bool, len(values))
+	for i, v := range values {
+		key := v.Name
+		if key == "" || used[key] {
+			key = strconv.Itoa(i)
+		}
+		used[key] = true
+		keys[i] = key
+	}
+	return keys
+}
+
+// MarshalJSON writes the values as a JSON object, preserving the order
+func (values DecodedValues) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	keys := values.keys()
+	for i, v := range values {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(keys[i])
+		value, err := json.Marshal(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// String formats the values as name (type): value pairs, with composite values as JSON
+func (values DecodedValues) String() string {
+	formatted := make([]string, len(values))
+	keys := values.keys()
+	for i, v := range values {
+		var value string
+		switch tv := v.Value.(type) {
+		case string:
+			value = tv
+		default:
+			b, _ := json.Marshal(tv)
+			value = string(b)
+		}
+		formatted[i] = fmt.Sprintf("%s (%s): %s", keys[i], v.Type, value)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// DecodeValues decodes ABI encoded data against the arguments, such as the outputs of a method
+func DecodeValues(args abi.Arguments, data []byte) (DecodedValues, error) {
+	unpacked, err := args.UnpackValues(data)
+	if err != nil {
+		return nil, err
+	}
+	values := make(DecodedValues, len(args))
+	for i, arg := range args {
+		name := arg.Name
+		if name == "" {
+			name = fmt.Sprintf("output%d", i)
+		}
+		values[i] = DecodedValue{
+			Name:  name,
+			Type:  arg.Type.String(),
+			Value: formatValue(arg.Type, reflect.ValueOf(unpacked[i])),
+		}
+	}
+	return values, nil
+}
+
+// formatValue converts a decoded Go value into a JSON friendly form: integers as
+// decimal strings, addresses and bytes as hex, and tuples as objects
+func formatValue(t abi.Type, v reflect.Value) interface{} {
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		if i, ok := v.Interface().(*big.Int); ok {
+			return i.String()
+		}
+		return fmt.Sprintf("%d", v.Interface())
+	case abi.BoolTy:
+		return v.Bool()
+	case abi.StringTy:
+		return v.String()
+	case abi.AddressTy:
+		return v.Interface().(common.Address).Hex()
+	case abi.BytesTy:
+		return hexutil.Encode(v.Bytes())
+	case abi.FixedBytesTy, abi.FunctionTy, abi.HashTy, abi.FixedPointTy:
+		b := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(b), v)
+		return hexutil.Encode(b)
+	case abi.SliceTy, abi.ArrayTy:
+		list := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			list[i] = formatValue(*t.Elem, v.Index(i))
+		}
+		return list
+	case abi.TupleTy:
+		fields := make(DecodedValues, len(t.TupleElems))
+		for i, elemType := range t.TupleElems {
+			fields[i] = DecodedValue{
+				Name:  t.TupleRawNames[i],
+				Type:  elemType.String(),
+				Value: formatValue(*elemType, v.Field(i)),
+			}
+		}
+		return fields
+	default:
+		return fmt.Sprintf("%v", v.Interface())
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const testResultsABI = `[
+	{"type": "function", "name": "named", "stateMutability": "view", "inputs": [], "outputs": [
+		{"name": "owner", "type": "address"},
+		{"name": "balance", "type": "uint256"},
+		{"name": "delta", "type": "int8"},
+		{"name": "active", "type": "bool"},
+		{"name": "label", "type": "string"},
+		{"name": "id", "type": "bytes4"},
+		{"name": "data", "type": "bytes"}
+	]},
+	{"type": "function", "name": "unnamed", "stateMutability": "view", "inputs": [], "outputs": [
+		{"name": "", "type": "uint256"}, {"name": "", "type": "string"}
+	]},
+	{"type": "function", "name": "nested", "stateMutability": "view", "inputs": [], "outputs": [
+		{"name": "order", "type": "tuple", "components": [
+			{"name": "id", "type": "uint64"},
+			{"name": "lines", "type": "tuple[]", "components": [
+				{"name": "sku", "type": "string"}, {"name": "qty", "type": "uint16"}
+			]}
+		]},
+		{"name": "", "type": "uint8[2]"}
+	]}
+]`
+
+// testDecodeOutputs packs the values for the outputs of a method, and decodes them again
+func testDecodeOutputs(t *testing.T, method string, values ...interface{}) DecodedValues {
+	contractABI, err := abi.JSON(strings.NewReader(testResultsABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := contractABI.Methods[method].Outputs
+	data, err := outputs.Pack(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeValues(outputs, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func testJSON(t *testing.T, values DecodedValues) string {
+	b, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestDecodeNamedValues(t *testing.T) {
+	owner := common.HexToAddress("0x0102030405060708090a0b0c0d0e0f1011121314")
+	values := testDecodeOutputs(t, "named", owner, big.NewInt(12345), int8(-3), true, "hello", [4]byte{0xde, 0xad, 0xbe, 0xef}, []byte{0x01, 0x02})
+	expected := `{"owner":"` + owner.Hex() + `","balance":"12345","delta":"-3","active":true,` +
+		`"label":"hello","id":"0xdeadbeef","data":"0x0102"}`
+	if j := testJSON(t, values); j != expected {
+		t.Errorf("expected %s, got %s", expected, j)
+	}
+	expectedText := "owner (address): " + owner.Hex() + ", balance (uint256): 12345, delta (int8): -3, " +
+		"active (bool): true, label (string): hello, id (bytes4): 0xdeadbeef, data (bytes): 0x0102"
+	if s := values.String(); s != expectedText {
+		t.Errorf("expected %s, got %s", expectedText, s)
+	}
+}
+
+func TestDecodeUnnamedValues(t *testing.T) {
+	values := testDecodeOutputs(t, "unnamed", big.NewInt(7), "seven")
+	if j := testJSON(t, values); j != `{"output0":"7","output1":"seven"}` {
+		t.Errorf("unexpected JSON %s", j)
+	}
+	if s := values.String(); s != "output0 (uint256): 7, output1 (string): seven" {
+		t.Errorf("unexpected text %s", s)
+	}
+}
+
+func TestDecodeNestedTuples(t *testing.T) {
+	type line struct {
+		Sku string
+		Qty uint16
+	}
+	order := struct {
+		Id    uint64
+		Lines []line
+	}{Id: 9, Lines: []line{{"apple", 2}, {"pear", 1}}}
+	values := testDecodeOutputs(t, "nested", order, [2]uint8{1, 2})
+	expected := `{"order":{"id":"9","lines":[{"sku":"apple","qty":"2"},{"sku":"pear","qty":"1"}]},"output1":["1","2"]}`
+	if j := testJSON(t, values); j != expected {
+		t.Errorf("expected %s, got %s", expected, j)
+	}
+	expectedText := `order ((uint64,(string,uint16)[])): {"id":"9","lines":[{"sku":"apple","qty":"2"},{"sku":"pear","qty":"1"}]}, ` +
+		`output1 (uint8[2]): ["1","2"]`
+	if s := values.String(); s != expectedText {
+		t.Errorf("expected %s, got %s", expectedText, s)
+	}
+}
+
+func TestUnnamedAndRepeatedKeys(t *testing.T) {
+	// The ABI parser requires component names, but tuples built in code might not have them
+	uint8Type, _ := abi.NewType("uint8", "", nil)
+	tupleType := abi.Type{
+		T:             abi.TupleTy,
+		TupleElems:    []*abi.Type{&uint8Type, &uint8Type},
+		TupleRawNames: []string{"", ""},
+		TupleType:     reflect.TypeOf(struct{ A, B uint8 }{}),
+	}
+	tuple := formatValue(tupleType, reflect.ValueOf(struct{ A, B uint8 }{1, 2}))
+	values := DecodedValues{
+		{Name: "a", Type: "uint8", Value: "1"},
+		{Name: "a", Type: "uint8", Value: "2"},
+		{Name: "", Type: "(uint8,uint8)", Value: tuple},
+	}
+	if j := testJSON(t, values); j != `{"a":"1","1":"2","2":{"0":"1","1":"2"}}` {
+		t.Errorf("unexpected JSON %s", j)
+	}
+	if s := values.String(); s != `a (uint8): 1, 1 (uint8): 2, 2 ((uint8,uint8)): {"0":"1","1":"2"}` {
+		t.Errorf("unexpected text %s", s)
+	}
+	if j := testJSON(t, DecodedValues{}); j != `{}` {
+		t.Errorf("unexpected JSON %s", j)
+	}
+}
+
+func TestDecodeValuesError(t *testing.T) {
+	contractABI, err := abi.JSON(strings.NewReader(testResultsABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeValues(contractABI.Methods["unnamed"].Outputs, []byte{0x01}); err == nil {
+		t.Errorf("expected an error for truncated data")
+	}
+}
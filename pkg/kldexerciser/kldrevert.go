@@ -0,0 +1,154 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/avast/retry-go"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	errorSelector = []byte{0x08, 0xc3, 0x79, 0xa0} // Error(string)
+	panicSelector = []byte{0x4e, 0x48, 0x7b, 0x71} // Panic(uint256)
+)
+
+// panicCodes are the reasons for the Panic(uint256) codes generated by the Solidity compiler
+var panicCodes = map[uint64]string{
+	0x00: "generic compiler inserted panic",
+	0x01: "assertion failed",
+	0x11: "arithmetic overflow or underflow",
+	0x12: "division or modulo by zero",
+	0x21: "invalid enum value",
+	0x22: "invalid storage byte array encoding",
+	0x31: "pop on empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to zero-initialized internal function",
+}
+
+// RevertReason is the decoded reason for a revert
+type RevertReason struct {
+	// Name is Error, Panic, the name of a custom error in the ABI, or Unknown
+	Name    string
+	Message string
+}
+
+func (r *RevertReason) String() string {
+	return fmt.Sprintf("%s: %s", r.Name, r.Message)
+}
+
+// DecodeRevert decodes revert data as Error(string), Panic(uint256), or a custom error from the ABI
+func DecodeRevert(contractABI abi.ABI, data []byte) *RevertReason {
+	if len(data) < 4 {
+		return &RevertReason{Name: "Unknown", Message: fmt.Sprintf("no reason returned (data=%s)", hexutil.Encode(data))}
+	}
+	selector := data[:4]
+	switch {
+	case bytes.Equal(selector, errorSelector):
+		if message, err := abi.UnpackRevert(data); err == nil {
+			return &RevertReason{Name: "Error", Message: message}
+		}
+	case bytes.Equal(selector, panicSelector):
+		uint256Type, _ := abi.NewType("uint256", "", nil)
+		if unpacked, err := (abi.Arguments{{Type: uint256Type}}).UnpackValues(data[4:]); err == nil {
+			code := unpacked[0].(*big.Int)
+			description, ok := panicCodes[code.Uint64()]
+			if !ok || !code.IsUint64() {
+				description = "unknown panic code"
+			}
+			return &RevertReason{Name: "Panic", Message: fmt.Sprintf("0x%02x (%s)", code, description)}
+		}
+	default:
+		for _, abiError := range contractABI.Errors {
+			if !bytes.Equal(selector, abiError.ID[:4]) {
+				continue
+			}
+			if values, err := DecodeValues(abiError.Inputs, data[4:]); err == nil {
+				return &RevertReason{Name: abiError.Name, Message: values.String()}
+			}
+		}
+	}
+	return &RevertReason{Name: "Unknown", Message: fmt.Sprintf("unrecognized revert data %s", hexutil.Encode(data))}
+}
+
+// revertData extracts the revert data returned in a JSON/RPC error, if any
+func revertData(err error) []byte {
+	if retryErrors, ok := err.(retry.Error); ok {
+		for _, e := range retryErrors {
+			if data := revertData(e); data != nil {
+				return data
+			}
+		}
+		return nil
+	}
+	var dataErr rpc.DataError
+	if err == nil || !errors.As(err, &dataErr) {
+		return nil
+	}
+	if hexData, ok := dataErr.ErrorData().(string); ok {
+		if data, err := hexutil.Decode(hexData); err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// revertReason decodes the reason from a failed call, if the node returned revert data
+func (w *Worker) revertReason(err error) *RevertReason {
+	data := revertData(err)
+	if data == nil {
+		return nil
+	}
+	return DecodeRevert(w.CompiledContract.ABI, data)
+}
+
+// replayFailedTx replays a mined transaction that failed as an eth_call against the
+// state of the block it was mined in, to recover the revert reason
+func (w *Worker) replayFailedTx(tx *types.Transaction, receipt *txnReceipt) *RevertReason {
+	if tx == nil || receipt.BlockNumber == nil {
+		return nil
+	}
+	data := hexutil.Bytes(tx.Data())
+	args := sendTxArgs{
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		From:     w.Account.Hex(),
+		Gas:      hexutil.Uint64(tx.Gas()),
+		GasPrice: hexutil.Big(*tx.GasPrice()),
+		Value:    hexutil.Big(*tx.Value()),
+		Data:     &data,
+	}
+	if tx.To() != nil {
+		args.To = tx.To().Hex()
+	}
+	var retValue string
+	err := w.rpcCall(&retValue, "eth_call", args, hexutil.EncodeBig(receipt.BlockNumber.ToInt()))
+	if err == nil {
+		w.debug("Replay of failed TX did not revert")
+		return nil
+	}
+	reason := w.revertReason(err)
+	if reason == nil {
+		w.debug("Replay of failed TX returned no revert data: %s", err)
+	}
+	return reason
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/avast/retry-go"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	ecrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+const testErrorsABI = `[
+	{"type": "error", "name": "InsufficientBalance", "inputs": [
+		{"name": "available", "type": "uint256"}, {"name": "required", "type": "uint256"}
+	]},
+	{"type": "error", "name": "Unauthorized", "inputs": []}
+]`
+
+// testRevertData is the selector of the error signature, followed by the ABI encoded values
+func testRevertData(t *testing.T, signature string, types []string, values ...interface{}) []byte {
+	var args abi.Arguments
+	for _, typeName := range types {
+		argType, err := abi.NewType(typeName, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, abi.Argument{Type: argType})
+	}
+	packed, err := args.Pack(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(ecrypto.Keccak256([]byte(signature))[:4], packed...)
+}
+
+func TestDecodeRevert(t *testing.T) {
+	contractABI, err := abi.JSON(strings.NewReader(testErrorsABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorData := testRevertData(t, "Error(string)", []string{"string"}, "not enough")
+	hugePanic, _ := new(big.Int).SetString("10000000000000011", 16)
+	tests := []struct {
+		name     string
+		data     []byte
+		expected RevertReason
+	}{
+		{"error", errorData, RevertReason{"Error", "not enough"}},
+		{"empty error", testRevertData(t, "Error(string)", []string{"string"}, ""), RevertReason{"Error", ""}},
+		{"assert", testRevertData(t, "Panic(uint256)", []string{"uint256"}, big.NewInt(0x01)), RevertReason{"Panic", "0x01 (assertion failed)"}},
+		{"overflow", testRevertData(t, "Panic(uint256)", []string{"uint256"}, big.NewInt(0x11)), RevertReason{"Panic", "0x11 (arithmetic overflow or underflow)"}},
+		{"index", testRevertData(t, "Panic(uint256)", []string{"uint256"}, big.NewInt(0x32)), RevertReason{"Panic", "0x32 (array index out of bounds)"}},
+		{"unknown panic", testRevertData(t, "Panic(uint256)", []string{"uint256"}, big.NewInt(0x99)), RevertReason{"Panic", "0x99 (unknown panic code)"}},
+		// The low 64 bits match a known code, but the code is too large
+		{"huge panic", testRevertData(t, "Panic(uint256)", []string{"uint256"}, hugePanic), RevertReason{"Panic", "0x10000000000000011 (unknown panic code)"}},
+		{"custom error", testRevertData(t, "InsufficientBalance(uint256,uint256)", []string{"uint256", "uint256"}, big.NewInt(5), big.NewInt(10)),
+			RevertReason{"InsufficientBalance", "available (uint256): 5, required (uint256): 10"}},
+		{"custom error without args", testRevertData(t, "Unauthorized()", nil), RevertReason{"Unauthorized", ""}},
+		{"unknown selector", []byte{0xde, 0xad, 0xbe, 0xef, 0x01}, RevertReason{"Unknown", "unrecognized revert data 0xdeadbeef01"}},
+		{"no data", nil, RevertReason{"Unknown", "no reason returned (data=0x)"}},
+		{"short data", []byte{0x08, 0xc3, 0x79}, RevertReason{"Unknown", "no reason returned (data=0x08c379)"}},
+		{"truncated error", errorData[:40], RevertReason{"Unknown", "unrecognized revert data " + hexutil.Encode(errorData[:40])}},
+		{"garbage panic", []byte{0x4e, 0x48, 0x7b, 0x71, 0xff}, RevertReason{"Unknown", "unrecognized revert data 0x4e487b71ff"}},
+		{"truncated custom error", testRevertData(t, "InsufficientBalance(uint256,uint256)", []string{"uint256"}, big.NewInt(5)),
+			RevertReason{"Unknown", "unrecognized revert data " + hexutil.Encode(testRevertData(t, "InsufficientBalance(uint256,uint256)", []string{"uint256"}, big.NewInt(5)))}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason := DecodeRevert(contractABI, test.data)
+			if !reflect.DeepEqual(*reason, test.expected) {
+				t.Errorf("expected %s, got %s", &test.expected, reason)
+			}
+		})
+	}
+}
+
+func TestRevertData(t *testing.T) {
+	revertErr := &testRevertError{data: hexutil.Bytes{0x01, 0x02}}
+	tests := []struct {
+		name     string
+		err      error
+		expected []byte
+	}{
+		{"data error", revertErr, []byte{0x01, 0x02}},
+		{"wrapped", fmt.Errorf("call failed: %w", revertErr), []byte{0x01, 0x02}},
+		{"retry errors", retry.Error{fmt.Errorf("timeout"), revertErr}, []byte{0x01, 0x02}},
+		{"retry errors without data", retry.Error{fmt.Errorf("timeout")}, nil},
+		{"empty data", &testRevertError{}, []byte{}},
+		{"plain error", fmt.Errorf("execution reverted"), nil},
+		{"nil", nil, nil},
+	}
+	for _, test := range tests {
+		if data := revertData(test.err); !reflect.DeepEqual(data, test.expected) {
+			t.Errorf("%s: expected %x, got %x", test.name, test.expected, data)
+		}
+	}
+}
+
+func TestReplayFailedTx(t *testing.T) {
+	node := newTestNode(t)
+	w := newTestWorker(t, newTestExerciser(node))
+	contractABI, err := abi.JSON(strings.NewReader(testErrorsABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.CompiledContract = &CompiledSolidity{ABI: contractABI}
+	to := common.HexToAddress("0xc0de")
+	tx := types.NewTransaction(3, to, big.NewInt(0), 100000, big.NewInt(0), []byte{0x01})
+	receipt := &txnReceipt{BlockNumber: (*hexutil.Big)(big.NewInt(0x2a))}
+
+	// The call is replayed at the block the transaction was mined in
+	node.callRevert = testRevertData(t, "InsufficientBalance(uint256,uint256)", []string{"uint256", "uint256"}, big.NewInt(5), big.NewInt(10))
+	reason := w.replayFailedTx(tx, receipt)
+	if reason == nil || reason.Name != "InsufficientBalance" {
+		t.Fatalf("unexpected reason %v", reason)
+	}
+	if !reflect.DeepEqual(node.callBlocks, []string{"0x2a"}) {
+		t.Errorf("expected a call at block 0x2a, got %v", node.callBlocks)
+	}
+
+	// No reason when the replay succeeds, and an unknown reason for empty revert data
+	node.callRevert = nil
+	if reason := w.replayFailedTx(tx, receipt); reason != nil {
+		t.Errorf("unexpected reason %s", reason)
+	}
+	node.callRevert = hexutil.Bytes{}
+	if reason := w.replayFailedTx(tx, receipt); reason == nil || reason.Name != "Unknown" {
+		t.Errorf("unexpected reason %v", reason)
+	}
+	// No reason when the call fails without revert data
+	failWith(node, "eth_call", 500)
+	if reason := w.replayFailedTx(tx, receipt); reason != nil {
+		t.Errorf("unexpected reason %s", reason)
+	}
+
+	// A transaction without a block number is not replayed
+	calls := node.requestCount("eth_call")
+	if reason := w.replayFailedTx(tx, &txnReceipt{}); reason != nil || node.requestCount("eth_call") != calls {
+		t.Errorf("replayed without a block number")
+	}
+}
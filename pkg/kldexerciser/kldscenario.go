@@ -0,0 +1,507 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is an ordered set of steps, run against the node in turn
+type Scenario struct {
+	Steps []*ScenarioStep `json:"steps"`
+}
+
+// ScenarioStep deploys a contract, sends a single transaction, makes a single call,
+// or runs a workload. Strings can refer to the results of earlier steps as ${step.field}
+type ScenarioStep struct {
+	Name            string        `json:"name"`
+	Action          string        `json:"action"`
+	Contract        string        `json:"contract"`
+	File            string        `json:"file"`
+	Artifact        string        `json:"artifact"`
+	ABI             string        `json:"abi"`
+	ContractName    string        `json:"contractName"`
+	ConstructorArgs []interface{} `json:"constructorArgs"`
+	Links           []string      `json:"links"`
+	Method          string        `json:"method"`
+	Args            []interface{} `json:"args"`
+	Mix             []*MixEntry   `json:"mix"`
+	ExpectEvent     string        `json:"expectEvent"`
+	Loops           int           `json:"loops"`
+	Transactions    int           `json:"transactions"`
+	VerifyCode      bool          `json:"verifyCode"`
+}
+
+// scenarioContract is a contract deployed by an earlier step
+type scenarioContract struct {
+	Compiled *CompiledSolidity
+	Address  common.Address
+}
+
+// scenarioRunner holds the state shared between the steps
+type scenarioRunner struct {
+	exerciser *Exerciser
+	endpoints *endpointPool
+	keys      []*ecdsa.PrivateKey
+	contracts map[string]*scenarioContract
+	vars      map[string]string
+}
+
+// scenarioVarRegex matches references to the results of earlier steps, such as ${tokenA.address}
+var scenarioVarRegex = regexp.MustCompile(`\$\{\s*([A-Za-z0-9_\-]+)\.([A-Za-z0-9_.]+)\s*\}`)
+
+// LoadScenario parses a YAML or JSON scenario file
+func LoadScenario(scenarioFile string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(scenarioFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read scenario %s: %s", scenarioFile, err)
+	}
+	// YAML is a superset of JSON. Convert to JSON to parse, so numbers keep their precision
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, fmt.Errorf("unable to parse scenario %s: %s", scenarioFile, err)
+	}
+	jsonData, err := json.Marshal(yamlNodeValue(&node))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse scenario %s: %s", scenarioFile, err)
+	}
+	var scenario Scenario
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&scenario); err != nil {
+		return nil, fmt.Errorf("unable to parse scenario %s: %s", scenarioFile, err)
+	}
+	if len(scenario.Steps) == 0 {
+		return nil, fmt.Errorf("scenario %s contains no steps", scenarioFile)
+	}
+	return &scenario, nil
+}
+
+// yamlNodeValue converts a YAML node into generic JSON values, with numbers as json.Number
+func yamlNodeValue(node *yaml.Node) interface{} {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return yamlNodeValue(node.Content[0])
+	case yaml.AliasNode:
+		return yamlNodeValue(node.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			m[node.Content[i].Value] = yamlNodeValue(node.Content[i+1])
+		}
+		return m
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(node.Content))
+		for i, child := range node.Content {
+			list[i] = yamlNodeValue(child)
+		}
+		return list
+	default:
+		switch node.ShortTag() {
+		case "!!null":
+			return nil
+		case "!!bool":
+			var b bool
+			if err := node.Decode(&b); err == nil {
+				return b
+			}
+		case "!!int", "!!float":
+			if json.Valid([]byte(node.Value)) {
+				return json.Number(node.Value)
+			}
+		}
+		return node.Value
+	}
+}
+
+// RunScenario runs each step of the scenario in order, stopping at the first failure
+func (e *Exerciser) RunScenario() error {
+	if e.Nonce != -1 {
+		return fmt.Errorf("a nonce cannot be specified with a scenario")
+	}
+	if e.SolidityFile != "" || e.ArtifactFile != "" || e.ABIFile != "" || e.Contract != "" || e.ContractName != "" ||
+		e.Method != "" || e.Mix != "" || len(e.Args) > 0 || e.ArgsFile != "" || len(e.ConstructorArgs) > 0 ||
+		len(e.Links) > 0 || e.ExpectEvent != "" || e.VerifyCode {
+		return fmt.Errorf("the contract, method, args, links, expected event and code verification must be specified in the steps of a scenario")
+	}
+	scenario, err := LoadScenario(e.Scenario)
+	if err != nil {
+		return err
+	}
+	r := &scenarioRunner{
+		exerciser: e,
+		contracts: make(map[string]*scenarioContract),
+		vars:      make(map[string]string),
+	}
+	if r.keys, r.endpoints, err = e.connect(); err != nil {
+		return err
+	}
+	defer r.endpoints.Close()
+	names := make(map[string]bool)
+	for i, step := range scenario.Steps {
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step%d", i)
+		}
+		if names[step.Name] {
+			return fmt.Errorf("duplicate scenario step name %s", step.Name)
+		}
+		names[step.Name] = true
+		log.Infof("Scenario step %d/%d: %s (%s)", i+1, len(scenario.Steps), step.Name, step.Action)
+		if err := r.runStep(step); err != nil {
+			return fmt.Errorf("scenario step %s failed: %s", step.Name, err)
+		}
+	}
+	log.Info("Scenario complete. Steps=", len(scenario.Steps))
+	return nil
+}
+
+// resolveVars substitutes the results of earlier steps into a string
+func (r *scenarioRunner) resolveVars(s string) (string, error) {
+	var err error
+	resolved := scenarioVarRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		match := scenarioVarRegex.FindStringSubmatch(ref)
+		value, ok := r.vars[match[1]+"."+match[2]]
+		if !ok && err == nil {
+			err = fmt.Errorf("%s does not refer to the result of an earlier step", ref)
+		}
+		return value
+	})
+	return resolved, err
+}
+
+// resolveArgVars substitutes the results of earlier steps into args, including JSON arrays and objects
+func (r *scenarioRunner) resolveArgVars(args []interface{}) ([]interface{}, error) {
+	resolved := make([]interface{}, len(args))
+	for i, arg := range args {
+		var err error
+		switch v := arg.(type) {
+		case string:
+			resolved[i], err = r.resolveVars(v)
+		case []interface{}:
+			resolved[i], err = r.resolveArgVars(v)
+		case map[string]interface{}:
+			fields := make(map[string]interface{}, len(v))
+			for name, field := range v {
+				var values []interface{}
+				if values, err = r.resolveArgVars([]interface{}{field}); err != nil {
+					break
+				}
+				fields[name] = values[0]
+			}
+			resolved[i] = fields
+		default:
+			resolved[i] = arg
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// argStrings formats args as strings for the command line fields, with composite values as JSON
+func argStrings(args []interface{}) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			strs[i] = v
+		case json.Number:
+			strs[i] = v.String()
+		case bool:
+			strs[i] = strconv.FormatBool(v)
+		default:
+			b, _ := json.Marshal(v)
+			strs[i] = string(b)
+		}
+	}
+	return strs
+}
+
+// stepExerciser creates the exerciser for a step. It is a copy of the exerciser from the
+// command line, so all the connection, account, gas, timing and compiler settings apply,
+// with the contract and method from the step and the state of the run reset
+func (r *scenarioRunner) stepExerciser(step *ScenarioStep) *Exerciser {
+	stepEx := *r.exerciser
+	// The contract and method of each step are its own
+	stepEx.Scenario = ""
+	stepEx.Contract = ""
+	stepEx.To = nil
+	stepEx.Method = ""
+	stepEx.Mix = ""
+	stepEx.Args = nil
+	stepEx.ArgsFile = ""
+	stepEx.ConstructorArgs = nil
+	stepEx.ExpectEvent = ""
+	stepEx.ExportFile = ""
+	stepEx.Links = nil
+	stepEx.ABI = ""
+	stepEx.SolidityFile = step.File
+	stepEx.ArtifactFile = step.Artifact
+	stepEx.ABIFile = step.ABI
+	stepEx.ContractName = step.ContractName
+	stepEx.VerifyCode = step.VerifyCode
+	// The counts and workload of the run
+	stepEx.TotalSuccesses = 0
+	stepEx.TotalFailures = 0
+	stepEx.txSeq = 0
+	stepEx.libraryLinks = nil
+	stepEx.expectedEvent = nil
+	stepEx.methods = nil
+	stepEx.totalWeight = 0
+	if step.Loops > 0 {
+		stepEx.Loops = step.Loops
+	}
+	if step.Transactions > 0 {
+		stepEx.TxnsPerLoop = step.Transactions
+	}
+	// Only a workload runs on all the workers, and only a workload can estimate gas
+	if step.Action != "workload" {
+		stepEx.Call = false
+		stepEx.EstimateGas = false
+		stepEx.Workers = 1
+	}
+	return &stepEx
+}
+
+func (r *scenarioRunner) runStep(step *ScenarioStep) (err error) {
+	stepEx := r.stepExerciser(step)
+	switch step.Action {
+	case "deploy":
+		return r.deploy(step, stepEx)
+	case "send", "call", "workload":
+		stepEx.Call = stepEx.Call || step.Action == "call"
+		return r.invoke(step, stepEx)
+	default:
+		return fmt.Errorf("unknown action '%s' (deploy, send, call or workload)", step.Action)
+	}
+}
+
+// deploy compiles and deploys a contract, which later steps can refer to by the step name
+func (r *scenarioRunner) deploy(step *ScenarioStep, stepEx *Exerciser) (err error) {
+	if step.Contract != "" || step.Method != "" || len(step.Mix) > 0 {
+		return fmt.Errorf("a contract address and methods cannot be specified when deploying")
+	}
+	if step.VerifyCode {
+		return fmt.Errorf("verifyCode only applies to a contract address, not a deployment")
+	}
+	constructorArgs, err := r.resolveArgVars(step.ConstructorArgs)
+	if err != nil {
+		return err
+	}
+	stepEx.ConstructorArgs = argStrings(constructorArgs)
+	for _, link := range step.Links {
+		if link, err = r.resolveVars(link); err != nil {
+			return err
+		}
+		stepEx.Links = append(stepEx.Links, link)
+	}
+	if err = stepEx.checkSources(); err != nil {
+		return err
+	}
+	compiled, err := stepEx.compileContract("deployment", nil)
+	if err != nil {
+		return err
+	}
+	if err = stepEx.prepareDeploy(compiled); err != nil {
+		return err
+	}
+	workers, err := stepEx.newWorkers(r.endpoints, compiled, r.keys)
+	if err != nil {
+		return err
+	}
+	addr, err := stepEx.deployContract(workers)
+	if err != nil {
+		return err
+	}
+	log.Infof("Scenario step %s: contract address=%s", step.Name, addr.Hex())
+	r.contracts[step.Name] = &scenarioContract{Compiled: compiled, Address: *addr}
+	r.vars[step.Name+".address"] = addr.Hex()
+	return nil
+}
+
+// invokeTarget resolves the contract for a step, from an earlier deploy step or an address
+func (r *scenarioRunner) invokeTarget(step *ScenarioStep, stepEx *Exerciser) (*CompiledSolidity, *common.Address, error) {
+	if deployed, ok := r.contracts[step.Contract]; ok {
+		if step.File != "" || step.Artifact != "" || step.ABI != "" {
+			return nil, nil, fmt.Errorf("a contract source cannot be specified for contract '%s' deployed by an earlier step", step.Contract)
+		}
+		addr := deployed.Address
+		return deployed.Compiled, &addr, nil
+	}
+	contract, err := r.resolveVars(step.Contract)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !common.IsHexAddress(contract) {
+		return nil, nil, fmt.Errorf("contract '%s' is not an earlier deploy step or an address", step.Contract)
+	}
+	stepEx.Contract = contract
+	if err = stepEx.checkSources(); err != nil {
+		return nil, nil, err
+	}
+	compiled, err := stepEx.compileContract(fmt.Sprintf("contract %s", contract), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr := common.HexToAddress(contract)
+	return compiled, &addr, nil
+}
+
+// invoke sends a transaction, makes a call, or runs a workload against the contract
+func (r *scenarioRunner) invoke(step *ScenarioStep, stepEx *Exerciser) (err error) {
+	compiled, addr, err := r.invokeTarget(step, stepEx)
+	if err != nil {
+		return err
+	}
+	stepEx.To = addr
+	if stepEx.VerifyCode && stepEx.Contract == "" {
+		return fmt.Errorf("verifyCode only applies to a contract address, not contract '%s' deployed by an earlier step", step.Contract)
+	}
+
+	var mix []*MixEntry
+	if len(step.Mix) > 0 {
+		if step.Action != "workload" || step.Method != "" || len(step.Args) > 0 {
+			return fmt.Errorf("a mix can only be specified instead of a method for a workload")
+		}
+		for _, entry := range step.Mix {
+			resolved := *entry
+			if resolved.Args, err = r.resolveArgVars(entry.Args); err != nil {
+				return err
+			}
+			mix = append(mix, &resolved)
+		}
+	} else {
+		args, err := r.resolveArgVars(step.Args)
+		if err != nil {
+			return err
+		}
+		call := stepEx.Call
+		mix = []*MixEntry{{Method: step.Method, Weight: 1, Args: args, Call: &call}}
+	}
+	if step.ExpectEvent != "" {
+		if stepEx.Call {
+			return fmt.Errorf("an expected event cannot be specified for calls")
+		}
+		if stepEx.expectedEvent, err = FindEvent(compiled.ABI, step.ExpectEvent); err != nil {
+			return err
+		}
+	}
+	if err = stepEx.initWorkload(compiled.ABI, mix); err != nil {
+		return err
+	}
+	workers, err := stepEx.newWorkers(r.endpoints, compiled, r.keys)
+	if err != nil {
+		return err
+	}
+	if stepEx.VerifyCode {
+		if err = workers[0].verifyDeployedCode(); err != nil {
+			return err
+		}
+	}
+
+	switch step.Action {
+	case "call":
+		return r.call(step, &workers[0])
+	case "send":
+		return r.send(step, &workers[0])
+	default:
+		if err = stepEx.runWorkers(workers); err != nil {
+			return err
+		}
+		r.vars[step.Name+".successes"] = strconv.FormatUint(stepEx.TotalSuccesses, 10)
+		r.vars[step.Name+".failures"] = strconv.FormatUint(stepEx.TotalFailures, 10)
+		return nil
+	}
+}
+
+// call makes a single call, storing the outputs by name
+func (r *scenarioRunner) call(step *ScenarioStep, w *Worker) error {
+	m := w.Exerciser.methods[0]
+	tx, err := w.generateTransaction(m)
+	if err != nil {
+		return err
+	}
+	values, err := w.callContract(m, tx)
+	if err != nil {
+		return err
+	}
+	r.storeValues(step.Name, values)
+	return nil
+}
+
+// send sends a single transaction and waits for the receipt, storing the details
+// of the receipt and the fields of the events emitted
+func (r *scenarioRunner) send(step *ScenarioStep, w *Worker) error {
+	m := w.Exerciser.methods[0]
+	tx, err := w.generateTransaction(m)
+	if err != nil {
+		return err
+	}
+	receipt, err := w.sendAndWaitForMining(tx, m.ExpectedEvent)
+	if err != nil {
+		return err
+	}
+	if receipt.Status == nil || receipt.Status.ToInt().Uint64() != 1 {
+		return fmt.Errorf("transaction %s failed", receipt.TransactionHash.Hex())
+	}
+	r.vars[step.Name+".txHash"] = receipt.TransactionHash.Hex()
+	r.vars[step.Name+".blockNumber"] = receipt.BlockNumber.ToInt().String()
+	r.vars[step.Name+".gasUsed"] = receipt.GasUsed.ToInt().String()
+	for _, l := range receipt.Logs {
+		if event, err := DecodeLog(w.CompiledContract.ABI, l); err == nil {
+			// The first of each event is available to later steps
+			prefix := step.Name + "." + event.Event.RawName
+			if _, exists := r.vars[prefix+".address"]; !exists {
+				r.vars[prefix+".address"] = l.Address.Hex()
+				r.storeValues(prefix, event.Values)
+			}
+		}
+	}
+	return nil
+}
+
+// storeValues stores decoded values for later steps, with the fields of tuples as name.field
+func (r *scenarioRunner) storeValues(prefix string, values DecodedValues) {
+	for _, v := range values {
+		key := prefix + "." + v.Name
+		switch tv := v.Value.(type) {
+		case string:
+			r.vars[key] = tv
+		case DecodedValues:
+			r.storeValues(key, tv)
+			b, _ := json.Marshal(tv)
+			r.vars[key] = string(b)
+		default:
+			b, _ := json.Marshal(tv)
+			r.vars[key] = string(b)
+		}
+		log.Debugf("Scenario result %s=%s", key, r.vars[key])
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testScenarioArtifact = `{
+	"_format": "hh-sol-artifact-1",
+	"contractName": "Store",
+	"sourceName": "contracts/Store.sol",
+	"abi": [
+		{"type": "constructor", "inputs": [], "stateMutability": "nonpayable"},
+		{"type": "function", "name": "set", "stateMutability": "nonpayable",
+			"inputs": [{"name": "value", "type": "uint256"}], "outputs": []}
+	],
+	"bytecode": "0x6080",
+	"deployedBytecode": "0x6080",
+	"linkReferences": {},
+	"deployedLinkReferences": {}
+}`
+
+func TestStepExerciserSettings(t *testing.T) {
+	e := newTestExerciser()
+	e.Workers = 3
+	e.Gas = 12345
+	e.Call = true
+	e.EstimateGas = true
+	e.VerifyCode = true
+	e.Contract = "0x0102030405060708090a0b0c0d0e0f1011121314"
+	e.Method = "global"
+	e.ExpectEvent = "Global"
+	e.ConstructorArgs = []string{"1"}
+	r := &scenarioRunner{exerciser: e}
+
+	step := &ScenarioStep{Action: "deploy", Artifact: "Store.json", ContractName: "Store", Loops: 7}
+	stepEx := r.stepExerciser(step)
+	if stepEx.ArtifactFile != "Store.json" || stepEx.ContractName != "Store" || stepEx.Loops != 7 || stepEx.Gas != 12345 {
+		t.Errorf("step settings not applied: %+v", stepEx)
+	}
+	if stepEx.VerifyCode || stepEx.Contract != "" || stepEx.Method != "" || stepEx.ExpectEvent != "" ||
+		len(stepEx.ConstructorArgs) > 0 || stepEx.Call || stepEx.EstimateGas {
+		t.Errorf("command line contract settings used by a step: %+v", stepEx)
+	}
+	if stepEx.Workers != 1 {
+		t.Errorf("expected 1 worker for a deploy, got %d", stepEx.Workers)
+	}
+
+	stepEx = r.stepExerciser(&ScenarioStep{Action: "workload", VerifyCode: true, Transactions: 9})
+	if stepEx.Workers != 3 || !stepEx.Call || !stepEx.EstimateGas || !stepEx.VerifyCode || stepEx.TxnsPerLoop != 9 {
+		t.Errorf("workload settings not applied: %+v", stepEx)
+	}
+}
+
+// stepOwnedFields are the exerciser fields a step sets itself, rather than copying
+var stepOwnedFields = map[string]bool{
+	"Scenario": true, "Contract": true, "To": true, "Method": true, "Mix": true, "Args": true,
+	"ArgsFile": true, "ConstructorArgs": true, "ExpectEvent": true, "ExportFile": true, "Links": true,
+	"ABI": true, "SolidityFile": true, "ArtifactFile": true, "ABIFile": true, "ContractName": true,
+	"VerifyCode": true, "TotalSuccesses": true, "TotalFailures": true,
+}
+
+func TestStepExerciserCopiesAllSettings(t *testing.T) {
+	// Every exported field is set, so a field added later cannot be silently dropped for scenarios
+	e := &Exerciser{}
+	v := reflect.ValueOf(e).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field, f := v.Type().Field(i), v.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString("set")
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			f.SetInt(7)
+		case reflect.Uint64:
+			f.SetUint(7)
+		case reflect.Slice:
+			f.Set(reflect.MakeSlice(f.Type(), 1, 1))
+		case reflect.Ptr:
+			f.Set(reflect.New(f.Type().Elem()))
+		default:
+			t.Fatalf("field %s has unhandled kind %s", field.Name, f.Kind())
+		}
+	}
+	r := &scenarioRunner{exerciser: e}
+	stepEx := reflect.ValueOf(r.stepExerciser(&ScenarioStep{Action: "workload"})).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		copied := reflect.DeepEqual(stepEx.Field(i).Interface(), v.Field(i).Interface())
+		if copied == stepOwnedFields[field.Name] {
+			t.Errorf("field %s copied=%t", field.Name, copied)
+		}
+	}
+}
+
+func TestScenarioDeployAndSend(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "Store.json", testScenarioArtifact, 0644)
+	scenarioFile := writeTestFile(t, dir, "scenario.yaml", `
+steps:
+  - name: store
+    action: deploy
+    artifact: `+dir+`/Store.json
+  - name: update
+    action: send
+    contract: store
+    method: set
+    args: [42]
+`, 0644)
+
+	node := newTestNode(t)
+	e := newTestExerciser(node)
+	e.Workers = 3
+	e.Accounts = append(e.Accounts,
+		"0x1112131415161718191a1b1c1d1e1f2021222324",
+		"0x2122232425262728292a2b2c2d2e2f3031323334")
+	e.Scenario = scenarioFile
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.sent) != 2 {
+		t.Fatalf("expected a deployment and a transaction, got %d", len(node.sent))
+	}
+	// Each step only initializes the worker it uses
+	if count := node.requestCount("eth_getTransactionCount"); count != 2 {
+		t.Errorf("expected 2 nonce queries, got %d", count)
+	}
+	if node.requestCount("eth_getCode") != 0 {
+		t.Errorf("code verified without verifyCode")
+	}
+}
+
+func TestScenarioRejectsCommandLineContract(t *testing.T) {
+	scenarioFile := writeTestFile(t, t.TempDir(), "scenario.yaml", "steps:\n  - action: call\n", 0644)
+	for name, set := range map[string]func(e *Exerciser){
+		"verify-code": func(e *Exerciser) { e.VerifyCode = true },
+		"contract":    func(e *Exerciser) { e.Contract = "0x0102030405060708090a0b0c0d0e0f1011121314" },
+		"method":      func(e *Exerciser) { e.Method = "set" },
+		"args":        func(e *Exerciser) { e.Args = []string{"1"} },
+		"file":        func(e *Exerciser) { e.SolidityFile = "Store.sol" },
+	} {
+		e := newTestExerciser(newTestNode(t))
+		e.Scenario = scenarioFile
+		set(e)
+		if err := e.Start(); err == nil || !strings.Contains(err.Error(), "must be specified in the steps of a scenario") {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+}
+
+func TestScenarioVerifyCodeErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "Store.json", testScenarioArtifact, 0644)
+	for name, steps := range map[string]string{
+		"deploy": "  - name: store\n    action: deploy\n    artifact: " + dir + "/Store.json\n    verifyCode: true\n",
+		"deployed": "  - name: store\n    action: deploy\n    artifact: " + dir + "/Store.json\n" +
+			"  - action: send\n    contract: store\n    method: set\n    args: [1]\n    verifyCode: true\n",
+	} {
+		e := newTestExerciser(newTestNode(t))
+		e.Scenario = writeTestFile(t, dir, "scenario.yaml", "steps:\n"+steps, 0644)
+		if err := e.Start(); err == nil || !strings.Contains(err.Error(), "verifyCode only applies to a contract address") {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+}
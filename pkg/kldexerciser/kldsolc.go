@@ -0,0 +1,353 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var pragmaExtractor = regexp.MustCompile(`pragma\s+solidity\s+([^;]+);`)
+
+// semver is a parsed major.minor.patch version
+type semver [3]int
+
+func parseSemver(ver string) (semver, error) {
+	var v semver
+	parts := strings.Split(strings.TrimPrefix(ver, "v"), ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("invalid version '%s'", ver)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, fmt.Errorf("invalid version '%s'", ver)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func (v semver) cmp(o semver) int {
+	for i := 0; i < 3; i++ {
+		if v[i] != o[i] {
+			if v[i] < o[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// versionComparator is a single comparison in a version range, such as ">=0.5.0"
+type versionComparator struct {
+	op  string
+	ver semver
+}
+
+func (c versionComparator) matches(v semver) bool {
+	r := v.cmp(c.ver)
+	switch c.op {
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	default:
+		return r == 0
+	}
+}
+
+// parsePartialVersion parses versions that might omit the minor/patch, or use x/*
+// wildcards. The number of specified parts is returned
+func parsePartialVersion(ver string) (semver, int, error) {
+	var v semver
+	parts := strings.Split(strings.TrimPrefix(ver, "v"), ".")
+	if len(parts) > 3 {
+		return v, 0, fmt.Errorf("invalid version '%s'", ver)
+	}
+	specified := 0
+	for i, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, 0, fmt.Errorf("invalid version '%s'", ver)
+		}
+		v[i] = n
+		specified++
+	}
+	return v, specified, nil
+}
+
+// bump increments the version part at the given index, zeroing the parts after it
+func (v semver) bump(idx int) semver {
+	v[idx]++
+	for i := idx + 1; i < 3; i++ {
+		v[i] = 0
+	}
+	return v
+}
+
+// parseComparator expands a single term of a range into one or more comparators
+func parseComparator(term string) ([]versionComparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, prefix) {
+			op = prefix
+			break
+		}
+	}
+	v, specified, err := parsePartialVersion(strings.TrimSpace(term[len(op):]))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case op == "^":
+		// Allow changes that do not modify the left-most non-zero part
+		idx := 0
+		for idx < specified-1 && v[idx] == 0 {
+			idx++
+		}
+		return []versionComparator{{">=", v}, {"<", v.bump(idx)}}, nil
+	case op == "~":
+		idx := 1
+		if specified < 2 {
+			idx = 0
+		}
+		return []versionComparator{{">=", v}, {"<", v.bump(idx)}}, nil
+	case specified == 0:
+		return []versionComparator{{">=", v}}, nil
+	case specified < 3 && (op == "" || op == "="):
+		return []versionComparator{{">=", v}, {"<", v.bump(specified - 1)}}, nil
+	case specified < 3 && op == "<=":
+		return []versionComparator{{"<", v.bump(specified - 1)}}, nil
+	case specified < 3 && op == ">":
+		return []versionComparator{{">=", v.bump(specified - 1)}}, nil
+	default:
+		return []versionComparator{{op, v}}, nil
+	}
+}
+
+// versionConstraint is a set of alternative ranges (separated by ||), each of
+// which is a set of comparators that must all match
+type versionConstraint [][]versionComparator
+
+// parseVersionConstraint parses the npm style semver range used in pragma solidity
+func parseVersionConstraint(constraint string) (versionConstraint, error) {
+	var vc versionConstraint
+	for _, alternative := range strings.Split(constraint, "||") {
+		var comparators []versionComparator
+		fields := strings.Fields(alternative)
+		for i := 0; i < len(fields); i++ {
+			term := fields[i]
+			// Join operators separated from the version by a space, such as ">= 0.5.0"
+			if strings.Trim(term, "<>=^~") == "" && i+1 < len(fields) {
+				i++
+				term += fields[i]
+			}
+			// Hyphen ranges, such as "0.5.0 - 0.6.0"
+			if i+2 < len(fields) && fields[i+1] == "-" {
+				lower, err := parseComparator(">=" + term)
+				if err != nil {
+					return nil, err
+				}
+				upper, err := parseComparator("<=" + fields[i+2])
+				if err != nil {
+					return nil, err
+				}
+				comparators = append(comparators, lower...)
+				comparators = append(comparators, upper...)
+				i += 2
+				continue
+			}
+			c, err := parseComparator(term)
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, c...)
+		}
+		if len(comparators) == 0 {
+			return nil, fmt.Errorf("empty version range in '%s'", constraint)
+		}
+		vc = append(vc, comparators)
+	}
+	return vc, nil
+}
+
+func (vc versionConstraint) matches(v semver) bool {
+	for _, comparators := range vc {
+		matched := true
+		for _, c := range comparators {
+			if !c.matches(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// stripComments removes the // and /* */ comments from Solidity source, leaving string
+// literals alone, so commented out statements are not matched
+func stripComments(source []byte) []byte {
+	stripped := make([]byte, 0, len(source))
+	for i := 0; i < len(source); i++ {
+		switch {
+		case source[i] == '"' || source[i] == '\'':
+			quote := source[i]
+			start := i
+			for i++; i < len(source) && source[i] != quote && source[i] != '\n'; i++ {
+				if source[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(source) {
+				i = len(source) - 1
+			}
+			stripped = append(stripped, source[start:i+1]...)
+		case bytes.HasPrefix(source[i:], []byte("//")):
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+			if i < len(source) {
+				stripped = append(stripped, '\n')
+			}
+		case bytes.HasPrefix(source[i:], []byte("/*")):
+			end := bytes.Index(source[i+2:], []byte("*/"))
+			if end < 0 {
+				return stripped
+			}
+			i += end + 3
+			stripped = append(stripped, ' ')
+		default:
+			stripped = append(stripped, source[i])
+		}
+	}
+	return stripped
+}
+
+// getSolidityPragmas extracts the version constraints from the pragma solidity statements,
+// ignoring any in comments. A file can have more than one, which must all be satisfied
+func getSolidityPragmas(solidityFile string) ([]string, error) {
+	source, err := ioutil.ReadFile(solidityFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", solidityFile, err)
+	}
+	var pragmas []string
+	for _, match := range pragmaExtractor.FindAllSubmatch(stripComments(source), -1) {
+		pragmas = append(pragmas, strings.TrimSpace(string(match[1])))
+	}
+	if len(pragmas) == 0 {
+		return nil, fmt.Errorf("no 'pragma solidity' version found in %s", solidityFile)
+	}
+	return pragmas, nil
+}
+
+// findSolcReleases finds the solc binaries in a directory, using the version in
+// the filename where available (solc-0.8.19, solc-linux-amd64-v0.8.19+commit.7dd6d404)
+// and otherwise running the binary to check its version
+func findSolcReleases(releasesDir string) ([]*SolcVersion, error) {
+	files, err := ioutil.ReadDir(releasesDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read solc releases directory %s: %s", releasesDir, err)
+	}
+	var releases []*SolcVersion
+	for _, f := range files {
+		if f.IsDir() || f.Mode()&0111 == 0 {
+			continue
+		}
+		solcPath := filepath.Join(releasesDir, f.Name())
+		if ver := solcVerExtractor.FindString(f.Name()); ver != "" {
+			releases = append(releases, &SolcVersion{Path: solcPath, Version: ver})
+		} else if solcVer, err := getSolcVersion(solcPath); err == nil {
+			releases = append(releases, solcVer)
+		} else {
+			log.Debugf("Skipping %s: %s", solcPath, err)
+		}
+	}
+	return releases, nil
+}
+
+// SelectSolc finds the newest solc binary in the releases directory that
+// satisfies the pragma solidity constraint in the source file
+func SelectSolc(solidityFile, releasesDir string) (*SolcVersion, error) {
+	pragmas, err := getSolidityPragmas(solidityFile)
+	if err != nil {
+		return nil, err
+	}
+	constraints := make([]versionConstraint, len(pragmas))
+	quoted := make([]string, len(pragmas))
+	for i, pragma := range pragmas {
+		quoted[i] = fmt.Sprintf("'pragma solidity %s'", pragma)
+		if constraints[i], err = parseVersionConstraint(pragma); err != nil {
+			return nil, fmt.Errorf("unable to parse %s in %s: %s", quoted[i], solidityFile, err)
+		}
+	}
+	pragmaDesc := strings.Join(quoted, " and ")
+	releases, err := findSolcReleases(releasesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []*SolcVersion
+	var available []string
+	for _, release := range releases {
+		v, err := parseSemver(release.Version)
+		if err != nil {
+			continue
+		}
+		available = append(available, release.Version)
+		matched := true
+		for _, constraint := range constraints {
+			matched = matched && constraint.matches(v)
+		}
+		if matched {
+			matching = append(matching, release)
+		}
+	}
+	if len(matching) == 0 {
+		sort.Strings(available)
+		return nil, fmt.Errorf("no solc release in %s matches %s in %s (available: %s)",
+			releasesDir, pragmaDesc, solidityFile, strings.Join(available, ", "))
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		vi, _ := parseSemver(matching[i].Version)
+		vj, _ := parseSemver(matching[j].Version)
+		return vi.cmp(vj) > 0
+	})
+	log.Debugf("Selected solc %s for %s", matching[0].Path, pragmaDesc)
+	return matching[0], nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{"0.8.19", []string{"0.8.19"}, []string{"0.8.18", "0.8.20"}},
+		{"=0.8.19", []string{"0.8.19"}, []string{"0.8.20"}},
+		{"^0.8.0", []string{"0.8.0", "0.8.99"}, []string{"0.7.6", "0.9.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.2", "0.0.4", "0.1.0"}},
+		{"^0.0", []string{"0.0.0", "0.0.9"}, []string{"0.1.0"}},
+		{"^0.x", []string{"0.0.1", "0.8.19"}, []string{"1.0.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}},
+		{"~0.4.24", []string{"0.4.24", "0.4.26"}, []string{"0.4.23", "0.5.0"}},
+		{"~0.4", []string{"0.4.0", "0.4.26"}, []string{"0.5.0"}},
+		{"~1", []string{"1.0.0", "1.9.9"}, []string{"2.0.0"}},
+		{"0.8", []string{"0.8.0", "0.8.30"}, []string{"0.7.6", "0.9.0"}},
+		{"0.8.x", []string{"0.8.0", "0.8.30"}, []string{"0.9.0"}},
+		{"*", []string{"0.4.0", "0.8.19"}, nil},
+		{">=0.5.0 <0.7.0", []string{"0.5.0", "0.6.12"}, []string{"0.4.26", "0.7.0"}},
+		{">= 0.5.0 < 0.7.0", []string{"0.5.0", "0.6.12"}, []string{"0.4.26", "0.7.0"}},
+		{">0.5.0", []string{"0.5.1"}, []string{"0.5.0"}},
+		{">0.5", []string{"0.6.0"}, []string{"0.5.17"}},
+		{"<=0.8", []string{"0.8.30"}, []string{"0.9.0"}},
+		{"<0.8", []string{"0.7.6"}, []string{"0.8.0"}},
+		{"<=0.8.4", []string{"0.8.4"}, []string{"0.8.5"}},
+		{"0.5.0 - 0.6", []string{"0.5.0", "0.6.12"}, []string{"0.4.26", "0.7.0"}},
+		{"0.5.0 - 0.6.1", []string{"0.6.1"}, []string{"0.6.2"}},
+		{"^0.4.24 || ^0.8.0", []string{"0.4.26", "0.8.19"}, []string{"0.5.0", "0.7.6"}},
+		{">=0.6.0 <0.8.0 || 0.8.19", []string{"0.7.6", "0.8.19"}, []string{"0.8.0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.constraint, func(t *testing.T) {
+			vc, err := parseVersionConstraint(test.constraint)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for _, ver := range test.match {
+				if v, _ := parseSemver(ver); !vc.matches(v) {
+					t.Errorf("expected %s to match", ver)
+				}
+			}
+			for _, ver := range test.noMatch {
+				if v, _ := parseSemver(ver); vc.matches(v) {
+					t.Errorf("expected %s not to match", ver)
+				}
+			}
+		})
+	}
+}
+
+func TestParseVersionConstraintErrors(t *testing.T) {
+	for _, constraint := range []string{"", "^0.8.0 ||", "0.8.a", "1.2.3.4", ">=abc"} {
+		if _, err := parseVersionConstraint(constraint); err == nil {
+			t.Errorf("expected an error for '%s'", constraint)
+		}
+	}
+}
+
+func writeTestFile(t *testing.T, dir, name, content string, mode os.FileMode) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSelectSolc(t *testing.T) {
+	releasesDir := t.TempDir()
+	for _, name := range []string{
+		"solc-0.4.26",
+		"solc-linux-amd64-v0.8.19+commit.7dd6d404",
+		"solc-0.8.4",
+		"solc-0.8.20",
+		"solc-0.7.6",
+	} {
+		writeTestFile(t, releasesDir, name, "", 0755)
+	}
+	// Not executable, so not a release even though it has a newer version
+	writeTestFile(t, releasesDir, "solc-0.8.30", "", 0644)
+
+	srcDir := t.TempDir()
+	tests := []struct {
+		pragma   string
+		expected string
+		err      string
+	}{
+		{"^0.8.0", "solc-0.8.20", ""},
+		{">=0.7.0 <0.8.5", "solc-0.8.4", ""},
+		{"0.8.19", "solc-linux-amd64-v0.8.19+commit.7dd6d404", ""},
+		{"^0.4.24 || ^0.7.0", "solc-0.7.6", ""},
+		{"^0.6.0", "", "available: 0.4.26, 0.7.6, 0.8.19, 0.8.20, 0.8.4"},
+	}
+	for _, test := range tests {
+		t.Run(test.pragma, func(t *testing.T) {
+			solFile := writeTestFile(t, srcDir, "test.sol",
+				"// SPDX-License-Identifier: Apache-2.0\npragma solidity "+test.pragma+";\ncontract Test {}\n", 0644)
+			solc, err := SelectSolc(solFile, releasesDir)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing '%s', got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if solc.Path != filepath.Join(releasesDir, test.expected) {
+				t.Errorf("expected %s, got %s", test.expected, solc.Path)
+			}
+		})
+	}
+}
+
+func TestSelectSolcPragmasInSource(t *testing.T) {
+	releasesDir := t.TempDir()
+	for _, name := range []string{"solc-0.4.26", "solc-0.8.4", "solc-0.8.20"} {
+		writeTestFile(t, releasesDir, name, "", 0755)
+	}
+	srcDir := t.TempDir()
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+		err      string
+	}{
+		{"line comment", "// pragma solidity ^0.4.0;\npragma solidity ^0.8.0;\n", "solc-0.8.20", ""},
+		{"block comment", "/* old:\npragma solidity ^0.4.0;\n*/\npragma solidity ^0.8.0;\n", "solc-0.8.20", ""},
+		{"doc comment", "/// pragma solidity ^0.4.0;\npragma solidity >=0.4.0;\n", "solc-0.8.20", ""},
+		{"all pragmas", "pragma solidity >=0.4.0;\npragma solidity <0.8.5;\n", "solc-0.8.4", ""},
+		{"no intersection", "pragma solidity ^0.4.0;\npragma solidity ^0.8.0;\n", "",
+			"matches 'pragma solidity ^0.4.0' and 'pragma solidity ^0.8.0'"},
+		{"only in comments", "// pragma solidity ^0.8.0;\n/* pragma solidity ^0.8.0; */\n", "", "no 'pragma solidity'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			solFile := writeTestFile(t, srcDir, "test.sol", test.source+"contract Test {}\n", 0644)
+			solc, err := SelectSolc(solFile, releasesDir)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing '%s', got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if solc.Path != filepath.Join(releasesDir, test.expected) {
+				t.Errorf("expected %s, got %s", test.expected, solc.Path)
+			}
+		})
+	}
+}
+
+func TestStripComments(t *testing.T) {
+	tests := map[string]string{
+		"a // comment\nb":                   "a \nb",
+		"a /* comment\n */b":                "a  b",
+		"a /** doc */ b /* c */":            "a   b  ",
+		`s = "// not a comment";`:           `s = "// not a comment";`,
+		`s = '/* not */' /* is */;`:         `s = '/* not */'  ;`,
+		`s = "escaped \" // quote"; // end`: `s = "escaped \" // quote"; `,
+		"a /* unterminated":                 "a ",
+		"a // no newline":                   "a ",
+	}
+	for source, expected := range tests {
+		if stripped := string(stripComments([]byte(source))); stripped != expected {
+			t.Errorf("%q: expected %q, got %q", source, expected, stripped)
+		}
+	}
+}
+
+func TestSelectSolcNoPragma(t *testing.T) {
+	solFile := writeTestFile(t, t.TempDir(), "test.sol", "contract Test {}\n", 0644)
+	if _, err := SelectSolc(solFile, t.TempDir()); err == nil || !strings.Contains(err.Error(), "no 'pragma solidity'") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// sourceMapEntry is the source range for an instruction, from the compressed solc
+// source map format s:l:f:j:m where empty fields repeat the previous entry
+type sourceMapEntry struct {
+	Start  int
+	Length int
+	File   int
+}
+
+// SourceLocation is a location in the Solidity source
+type SourceLocation struct {
+	File    string
+	Line    int
+	Column  int
+	Snippet string
+}
+
+func (l *SourceLocation) String() string {
+	if l.Line == 0 {
+		return l.File
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", l.File, l.Line, l.Column, l.Snippet)
+}
+
+// parseSourceMap decompresses a solc source map into an entry per instruction
+func parseSourceMap(srcMap string) ([]sourceMapEntry, error) {
+	var entries []sourceMapEntry
+	var current sourceMapEntry
+	for i, item := range strings.Split(srcMap, ";") {
+		fields := strings.Split(item, ":")
+		for f, target := range []*int{&current.Start, &current.Length, &current.File} {
+			if f >= len(fields) || fields[f] == "" {
+				continue
+			}
+			v, err := strconv.Atoi(fields[f])
+			if err != nil {
+				return nil, fmt.Errorf("invalid source map entry %d '%s'", i, item)
+			}
+			*target = v
+		}
+		entries = append(entries, current)
+	}
+	return entries, nil
+}
+
+// instructionIndex finds the index of the instruction at the program counter, skipping push data
+func instructionIndex(code []byte, pc uint64) (int, error) {
+	index := 0
+	for offset := uint64(0); offset < uint64(len(code)); offset++ {
+		if offset == pc {
+			return index, nil
+		}
+		op := vm.OpCode(code[offset])
+		if op >= vm.PUSH1 && op <= vm.PUSH32 {
+			offset += uint64(op - vm.PUSH1 + 1)
+		}
+		index++
+	}
+	return 0, fmt.Errorf("pc %d is not the start of an instruction in the %d bytes of code", pc, len(code))
+}
+
+// lineAndColumn finds the 1-based line and column of an offset, along with the text of the line
+func lineAndColumn(source []byte, offset int) (line, column int, text string) {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	lineStart := bytes.LastIndexByte(source[:offset], '\n') + 1
+	lineEnd := bytes.IndexByte(source[offset:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(source)
+	} else {
+		lineEnd += offset
+	}
+	line = bytes.Count(source[:offset], []byte{'\n'}) + 1
+	return line, offset - lineStart + 1, strings.TrimSpace(string(source[lineStart:lineEnd]))
+}
+
+// SourceLocation maps a program counter in the deployed code of the contract to the
+// location in the Solidity source, using the runtime source map from compilation
+func (c *CompiledSolidity) SourceLocation(runtimeCode []byte, pc uint64) (*SourceLocation, error) {
+	srcMap := c.ContractInfo.SrcMapRuntime
+	if srcMap == "" {
+		return nil, fmt.Errorf("no runtime source map available for the contract")
+	}
+	entries, err := parseSourceMap(srcMap)
+	if err != nil {
+		return nil, err
+	}
+	index, err := instructionIndex(runtimeCode, pc)
+	if err != nil {
+		return nil, err
+	}
+	if index >= len(entries) {
+		return nil, fmt.Errorf("instruction %d at pc %d is beyond the %d entries in the source map", index, pc, len(entries))
+	}
+	entry := entries[index]
+	if entry.File < 0 {
+		return &SourceLocation{File: "compiler generated code"}, nil
+	}
+	if entry.File >= len(c.SourceFiles) || c.SourceFiles[entry.File] == "" {
+		return &SourceLocation{File: fmt.Sprintf("source %d (offset %d)", entry.File, entry.Start)}, nil
+	}
+	file := c.SourceFiles[entry.File]
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", file, err)
+	}
+	loc := &SourceLocation{File: file}
+	loc.Line, loc.Column, loc.Snippet = lineAndColumn(source, entry.Start)
+	return loc, nil
+}
+
+// structLog is a step in the trace returned by debug_traceTransaction
+type structLog struct {
+	PC    uint64 `json:"pc"`
+	Op    string `json:"op"`
+	Depth int    `json:"depth"`
+}
+
+type txTrace struct {
+	Failed     bool        `json:"failed"`
+	StructLogs []structLog `json:"structLogs"`
+}
+
+// traceFailure traces a failed transaction with debug_traceTransaction, and maps the
+// last instruction executed in the contract to the Solidity source
+func (w *Worker) traceFailure(receipt *txnReceipt) (*SourceLocation, error) {
+	if receipt.To == nil || w.Exerciser.To == nil || *receipt.To != *w.Exerciser.To {
+		return nil, fmt.Errorf("the transaction was not sent to the contract")
+	}
+	var trace txTrace
+	traceOptions := map[string]interface{}{
+		"disableStack":   true,
+		"disableStorage": true,
+	}
+	if err := w.rpcCall(&trace, "debug_traceTransaction", receipt.TransactionHash.Hex(), traceOptions); err != nil {
+		return nil, fmt.Errorf("debug_traceTransaction failed: %s", err)
+	}
+	// Calls into other contracts are at a greater depth, so find the last
+	// instruction executed in the contract itself
+	var failed *structLog
+	for i := len(trace.StructLogs) - 1; i >= 0; i-- {
+		if trace.StructLogs[i].Depth == 1 {
+			failed = &trace.StructLogs[i]
+			break
+		}
+	}
+	if failed == nil {
+		return nil, fmt.Errorf("no instructions in the trace")
+	}
+	w.debug("TX:%s failed at pc=%d op=%s", receipt.TransactionHash.Hex(), failed.PC, failed.Op)
+
+	var code hexutil.Bytes
+	if err := w.rpcCall(&code, "eth_getCode", receipt.To.Hex(), hexutil.EncodeBig(receipt.BlockNumber.ToInt())); err != nil {
+		return nil, fmt.Errorf("eth_getCode failed: %s", err)
+	}
+	return w.CompiledContract.SourceLocation(code, failed.PC)
+}
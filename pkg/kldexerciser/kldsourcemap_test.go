@@ -0,0 +1,102 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/compiler"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestParseSourceMap(t *testing.T) {
+	tests := []struct {
+		srcMap   string
+		expected []sourceMapEntry
+	}{
+		// The example from the solc documentation, where empty fields repeat the previous entry
+		{"1:2:1;:9;2:1:2;;", []sourceMapEntry{{1, 2, 1}, {1, 9, 1}, {2, 1, 2}, {2, 1, 2}, {2, 1, 2}}},
+		// The jump (j) and modifier depth (m) fields are ignored, including when inherited
+		{"0:120:0:-:0;45:3::i;;::-1:o:1;60", []sourceMapEntry{{0, 120, 0}, {45, 3, 0}, {45, 3, 0}, {45, 3, -1}, {60, 3, -1}}},
+		{"5:10:0", []sourceMapEntry{{5, 10, 0}}},
+	}
+	for _, test := range tests {
+		t.Run(test.srcMap, func(t *testing.T) {
+			entries, err := parseSourceMap(test.srcMap)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(entries, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, entries)
+			}
+		})
+	}
+	if _, err := parseSourceMap("1:2:1;x:9"); err == nil || !strings.Contains(err.Error(), "invalid source map entry 1 'x:9'") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// testRuntimeCode is PUSH1 0x80, PUSH1 0x40, MSTORE, PUSH32 <32 bytes>, PUSH2 0x0102, JUMPDEST, REVERT
+var testRuntimeCode = bytes.Join([][]byte{
+	{byte(vm.PUSH1), 0x80, byte(vm.PUSH1), 0x40, byte(vm.MSTORE)},
+	append([]byte{byte(vm.PUSH32)}, bytes.Repeat([]byte{byte(vm.PUSH1)}, 32)...),
+	{byte(vm.PUSH2), 0x01, 0x02, byte(vm.JUMPDEST), byte(vm.REVERT)},
+}, nil)
+
+func TestInstructionIndex(t *testing.T) {
+	for pc, index := range map[uint64]int{0: 0, 2: 1, 4: 2, 5: 3, 38: 4, 41: 5, 42: 6} {
+		if i, err := instructionIndex(testRuntimeCode, pc); err != nil || i != index {
+			t.Errorf("pc %d: expected instruction %d, got %d (%v)", pc, index, i, err)
+		}
+	}
+	// Push data, including bytes that look like opcodes, is not an instruction
+	for _, pc := range []uint64{1, 3, 6, 37, 39, 43} {
+		if _, err := instructionIndex(testRuntimeCode, pc); err == nil {
+			t.Errorf("pc %d: expected an error", pc)
+		}
+	}
+}
+
+func TestSourceLocation(t *testing.T) {
+	source := "pragma solidity ^0.8.0;\ncontract Store {\n    function set() public {\n        revert(\"no\");\n    }\n}\n"
+	file := writeTestFile(t, t.TempDir(), "Store.sol", source, 0644)
+	revertOffset := strings.Index(source, "revert")
+	// One entry per instruction of testRuntimeCode. The REVERT maps to the revert statement
+	c := &CompiledSolidity{
+		ContractInfo: compiler.ContractInfo{SrcMapRuntime: "0:80:0;;;;::-1;25:10:0;" + strconv.Itoa(revertOffset) + ":12:0"},
+		SourceFiles:  []string{file},
+	}
+	loc, err := c.SourceLocation(testRuntimeCode, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Line != 4 || loc.Column != 9 || loc.Snippet != `revert("no");` {
+		t.Errorf("unexpected location %s", loc)
+	}
+	if loc, _ = c.SourceLocation(testRuntimeCode, 38); loc.File != "compiler generated code" {
+		t.Errorf("unexpected location %s", loc)
+	}
+	if _, err = c.SourceLocation(testRuntimeCode[:41], 40); err == nil {
+		t.Errorf("expected an error for push data")
+	}
+	c.ContractInfo.SrcMapRuntime = "0:80:0"
+	if _, err = c.SourceLocation(testRuntimeCode, 42); err == nil || !strings.Contains(err.Error(), "beyond the 1 entries") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// argTemplateRegex matches templates such as {{seq}} and {{rand:uint256}} in args
+var argTemplateRegex = regexp.MustCompile(`\{\{\s*([A-Za-z]+)(?::([A-Za-z0-9]+))?\s*\}\}`)
+
+// argTemplateContext is the state templates are evaluated against for each transaction
+type argTemplateContext struct {
+	worker int
+	seq    uint64
+	loop   uint64
+	rand   *rand.Rand
+}
+
+// hasArgTemplates checks for templates in any string, including within JSON arrays and objects
+func hasArgTemplates(args []interface{}) bool {
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			if argTemplateRegex.MatchString(v) {
+				return true
+			}
+		case []interface{}:
+			if hasArgTemplates(v) {
+				return true
+			}
+		case map[string]interface{}:
+			for _, field := range v {
+				if hasArgTemplates([]interface{}{field}) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// expandArgTemplates returns a copy of the args with each template replaced by its value
+func expandArgTemplates(args []interface{}, ctx *argTemplateContext) ([]interface{}, error) {
+	expanded := make([]interface{}, len(args))
+	for i, arg := range args {
+		var err error
+		if expanded[i], err = expandArgTemplate(arg, ctx); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+func expandArgTemplate(arg interface{}, ctx *argTemplateContext) (interface{}, error) {
+	switch v := arg.(type) {
+	case string:
+		var err error
+		expanded := argTemplateRegex.ReplaceAllStringFunc(v, func(template string) string {
+			match := argTemplateRegex.FindStringSubmatch(template)
+			value, templateErr := ctx.evaluate(match[1], match[2])
+			if templateErr != nil && err == nil {
+				err = fmt.Errorf("invalid template %s: %s", template, templateErr)
+			}
+			return value
+		})
+		return expanded, err
+	case []interface{}:
+		return expandArgTemplates(v, ctx)
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for name, field := range v {
+			var err error
+			if expanded[name], err = expandArgTemplate(field, ctx); err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	default:
+		return arg, nil
+	}
+}
+
+// evaluate returns the value of a single template
+func (ctx *argTemplateContext) evaluate(name, param string) (string, error) {
+	if name != "rand" && param != "" {
+		return "", fmt.Errorf("'%s' does not take a parameter", name)
+	}
+	switch name {
+	case "seq":
+		return strconv.FormatUint(ctx.seq, 10), nil
+	case "worker":
+		return strconv.Itoa(ctx.worker), nil
+	case "loop":
+		return strconv.FormatUint(ctx.loop, 10), nil
+	case "rand":
+		return randomValue(ctx.rand, param)
+	case "randAddress":
+		return randomValue(ctx.rand, "address")
+	case "uuid":
+		return randomUUID(ctx.rand), nil
+	default:
+		return "", fmt.Errorf("unknown template '%s' (seq, worker, loop, rand:<type>, randAddress or uuid)", name)
+	}
+}
+
+// randomValue generates a random value across the full range of a Solidity type
+func randomValue(r *rand.Rand, typeName string) (string, error) {
+	if typeName == "" {
+		return "", fmt.Errorf("a type is required, such as rand:uint256")
+	}
+	t, err := abi.NewType(typeName, "", nil)
+	if err != nil {
+		return "", err
+	}
+	switch t.T {
+	case abi.UintTy:
+		limit := new(big.Int).Lsh(big.NewInt(1), uint(t.Size))
+		return new(big.Int).Rand(r, limit).String(), nil
+	case abi.IntTy:
+		limit := new(big.Int).Lsh(big.NewInt(1), uint(t.Size))
+		offset := new(big.Int).Lsh(big.NewInt(1), uint(t.Size-1))
+		return new(big.Int).Sub(new(big.Int).Rand(r, limit), offset).String(), nil
+	case abi.BoolTy:
+		return strconv.FormatBool(r.Intn(2) == 1), nil
+	case abi.AddressTy:
+		return randomHex(r, 20), nil
+	case abi.FixedBytesTy:
+		return randomHex(r, t.Size), nil
+	default:
+		return "", fmt.Errorf("random values of type %s are not supported", typeName)
+	}
+}
+
+func randomHex(r *rand.Rand, size int) string {
+	b := make([]byte, size)
+	r.Read(b)
+	return hexutil.Encode(b)
+}
+
+// randomUUID generates a version 4 UUID
+func randomUUID(r *rand.Rand) string {
+	b := make([]byte, 16)
+	r.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := strings.TrimPrefix(hexutil.Encode(b), "0x")
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+}
+
+// packTemplatedCall evaluates the arg templates for the next transaction, and packs the call.
+// The sequence number is shared by all workers, so it is unique across the run
+func (w *Worker) packTemplatedCall(m *workloadMethod) ([]byte, error) {
+	args, err := expandArgTemplates(m.ArgTemplates, &argTemplateContext{
+		worker: w.Index,
+		seq:    atomic.AddUint64(&w.Exerciser.txSeq, 1) - 1,
+		loop:   w.LoopIndex,
+		rand:   w.rand,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return packCall(m.Method, args)
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"math/big"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestHasArgTemplates(t *testing.T) {
+	tests := []struct {
+		args     []interface{}
+		expected bool
+	}{
+		{[]interface{}{"plain", "{{ not a template"}, false},
+		{[]interface{}{"key-{{seq}}"}, true},
+		{[]interface{}{"{{ rand:uint8 }}"}, true},
+		{[]interface{}{[]interface{}{"a", []interface{}{"{{worker}}"}}}, true},
+		{[]interface{}{map[string]interface{}{"id": "{{uuid}}"}}, true},
+		{[]interface{}{map[string]interface{}{"id": 1}}, false},
+	}
+	for _, test := range tests {
+		if hasArgTemplates(test.args) != test.expected {
+			t.Errorf("%v: expected %t", test.args, test.expected)
+		}
+	}
+}
+
+func TestExpandArgTemplates(t *testing.T) {
+	ctx := &argTemplateContext{worker: 3, seq: 42, loop: 7, rand: rand.New(rand.NewSource(1))}
+	args := []interface{}{
+		"key-{{worker}}-{{seq}}",
+		[]interface{}{"{{loop}}", "{{ seq }}"},
+		map[string]interface{}{"id": "{{seq}}", "count": 1},
+		true,
+	}
+	expanded, err := expandArgTemplates(args, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		"key-3-42",
+		[]interface{}{"7", "42"},
+		map[string]interface{}{"id": "42", "count": 1},
+		true,
+	}
+	if !reflect.DeepEqual(expanded, expected) {
+		t.Errorf("expected %v, got %v", expected, expanded)
+	}
+	// The original args are re-used for every transaction, so must not change
+	if args[0] != "key-{{worker}}-{{seq}}" || args[2].(map[string]interface{})["id"] != "{{seq}}" {
+		t.Errorf("args modified: %v", args)
+	}
+}
+
+func TestExpandArgTemplatesErrors(t *testing.T) {
+	ctx := &argTemplateContext{rand: rand.New(rand.NewSource(1))}
+	tests := []struct {
+		arg interface{}
+		err string
+	}{
+		{"{{nope}}", "unknown template 'nope'"},
+		{"{{seq:uint8}}", "'seq' does not take a parameter"},
+		{"{{rand}}", "a type is required"},
+		{"{{rand:string}}", "random values of type string are not supported"},
+		{"{{rand:foo}}", "invalid template {{rand:foo}}"},
+		{[]interface{}{map[string]interface{}{"a": "{{nope}}"}}, "unknown template"},
+	}
+	for _, test := range tests {
+		if _, err := expandArgTemplates([]interface{}{test.arg}, ctx); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected error containing '%s', got %v", test.arg, test.err, err)
+		}
+	}
+}
+
+func TestRandomValues(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	int8Min, int8Max := big.NewInt(-128), big.NewInt(127)
+	for i := 0; i < 200; i++ {
+		v, err := randomValue(r, "int8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := new(big.Int).SetString(v, 10)
+		if n.Cmp(int8Min) < 0 || n.Cmp(int8Max) > 0 {
+			t.Fatalf("int8 out of range: %s", v)
+		}
+		v, _ = randomValue(r, "uint16")
+		if n, _ = new(big.Int).SetString(v, 10); n.Sign() < 0 || n.Cmp(big.NewInt(65535)) > 0 {
+			t.Fatalf("uint16 out of range: %s", v)
+		}
+	}
+	if v, _ := randomValue(r, "address"); !common.IsHexAddress(v) {
+		t.Errorf("invalid address %s", v)
+	}
+	if v, _ := randomValue(r, "bytes4"); len(v) != 10 {
+		t.Errorf("invalid bytes4 %s", v)
+	}
+	if v, _ := randomValue(r, "bool"); v != "true" && v != "false" {
+		t.Errorf("invalid bool %s", v)
+	}
+	uuidRegex := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if v := randomUUID(r); !uuidRegex.MatchString(v) {
+		t.Errorf("invalid uuid %s", v)
+	}
+}
+
+func TestTemplatedSeqUniqueAcrossWorkers(t *testing.T) {
+	method := abi.NewMethod("store", "store", abi.Function, "nonpayable", false, false,
+		abi.Arguments{{Name: "value", Type: mustNewType(t, "uint256", nil)}}, nil)
+	m := &workloadMethod{
+		Method:       &method,
+		ArgTemplates: []interface{}{"{{seq}}"},
+	}
+	e := &Exerciser{}
+	const workers, txns = 4, 50
+	var mux sync.Mutex
+	seen := make(map[uint64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(w *Worker) {
+			defer wg.Done()
+			for j := 0; j < txns; j++ {
+				data, err := w.packTemplatedCall(m)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				seq := new(big.Int).SetBytes(data[4:]).Uint64()
+				mux.Lock()
+				if seen[seq] {
+					t.Errorf("duplicate seq %d", seq)
+				}
+				seen[seq] = true
+				mux.Unlock()
+			}
+		}(&Worker{Index: i, Exerciser: e, rand: rand.New(rand.NewSource(int64(i)))})
+	}
+	wg.Wait()
+	for seq := uint64(0); seq < workers*txns; seq++ {
+		if !seen[seq] {
+			t.Errorf("missing seq %d", seq)
+		}
+	}
+}
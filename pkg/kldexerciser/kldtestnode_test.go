@@ -0,0 +1,264 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexcesaro/statsd"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	ecrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testNode is a minimal Ethereum JSON/RPC node for the tests, which mines each
+// transaction as soon as it is sent
+type testNode struct {
+	server   *httptest.Server
+	mux      sync.Mutex
+	sent     []hexutil.Bytes
+	receipts map[common.Hash]map[string]interface{}
+	requests map[string]int
+	status   uint64
+	logs     []*types.Log
+	code     hexutil.Bytes
+	callData hexutil.Bytes
+	// callRevert is returned as the revert data of calls, with the blocks they were made against
+	callRevert hexutil.Bytes
+	callBlocks []string
+	intercept  func(method string, w http.ResponseWriter) bool
+}
+
+type testEthService struct {
+	node *testNode
+}
+
+func newTestNode(t *testing.T) *testNode {
+	n := &testNode{
+		receipts: make(map[common.Hash]map[string]interface{}),
+		requests: make(map[string]int),
+		status:   1,
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &testEthService{node: n}); err != nil {
+		t.Fatal(err)
+	}
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req struct {
+			Method string `json:"method"`
+		}
+		json.Unmarshal(body, &req)
+		n.mux.Lock()
+		n.requests[req.Method]++
+		intercept := n.intercept
+		n.mux.Unlock()
+		if intercept != nil && intercept(req.Method, w) {
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		n.server.Close()
+		server.Stop()
+	})
+	return n
+}
+
+func (n *testNode) requestCount(method string) int {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.requests[method]
+}
+
+// mine records a sent transaction, with a receipt that has a contract address
+// for deployments
+func (n *testNode) mine(data []byte, deploy bool) common.Hash {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.sent = append(n.sent, data)
+	txHash := ecrypto.Keccak256Hash([]byte(fmt.Sprintf("tx%d", len(n.sent))), data)
+	receipt := map[string]interface{}{
+		"blockHash":         common.Hash{1},
+		"blockNumber":       "0x1",
+		"cumulativeGasUsed": "0x5208",
+		"gasUsed":           "0x5208",
+		"status":            hexutil.Uint64(n.status),
+		"transactionHash":   txHash,
+		"transactionIndex":  "0x0",
+		"logs":              n.logs,
+	}
+	if deploy {
+		receipt["contractAddress"] = testContractAddress(len(n.sent))
+	}
+	n.receipts[txHash] = receipt
+	return txHash
+}
+
+// testContractAddress is the address of the contract deployed by the nth transaction
+func testContractAddress(n int) common.Address {
+	return common.BytesToAddress([]byte{0xc0, 0xde, byte(n)})
+}
+
+func (s *testEthService) SendTransaction(args sendTxArgs) (common.Hash, error) {
+	var data []byte
+	if args.Data != nil {
+		data = *args.Data
+	}
+	return s.node.mine(data, args.To == ""), nil
+}
+
+func (s *testEthService) SendRawTransaction(raw hexutil.Bytes) (common.Hash, error) {
+	var tx types.Transaction
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return common.Hash{}, err
+	}
+	return s.node.mine(tx.Data(), tx.To() == nil), nil
+}
+
+func (s *testEthService) GetTransactionReceipt(txHash common.Hash) (map[string]interface{}, error) {
+	s.node.mux.Lock()
+	defer s.node.mux.Unlock()
+	return s.node.receipts[txHash], nil
+}
+
+func (s *testEthService) GetTransactionCount(addr common.Address, block string) (hexutil.Uint64, error) {
+	return 0, nil
+}
+
+func (s *testEthService) BlockNumber() (hexutil.Uint64, error) {
+	return 1, nil
+}
+
+func (s *testEthService) GetCode(addr common.Address, block string) (hexutil.Bytes, error) {
+	s.node.mux.Lock()
+	defer s.node.mux.Unlock()
+	return s.node.code, nil
+}
+
+func (s *testEthService) EstimateGas(args sendTxArgs) (hexutil.Uint64, error) {
+	return 21000, nil
+}
+
+func (s *testEthService) Call(args sendTxArgs, block string) (hexutil.Bytes, error) {
+	s.node.mux.Lock()
+	defer s.node.mux.Unlock()
+	s.node.callBlocks = append(s.node.callBlocks, block)
+	if s.node.callRevert != nil {
+		return nil, &testRevertError{data: s.node.callRevert}
+	}
+	return s.node.callData, nil
+}
+
+// testRevertError is returned by the node for a reverted call, with the revert data as geth does
+type testRevertError struct {
+	data hexutil.Bytes
+}
+
+func (e *testRevertError) Error() string          { return "execution reverted" }
+func (e *testRevertError) ErrorCode() int         { return 3 }
+func (e *testRevertError) ErrorData() interface{} { return e.data.String() }
+
+// newTestExerciser has the settings to run workers quickly against test nodes
+func newTestExerciser(nodes ...*testNode) *Exerciser {
+	e := &Exerciser{
+		URLStrategy:    StrategyPin,
+		RPCTimeout:     5,
+		ReceiptWaitMin: 0,
+		ReceiptWaitMax: 5,
+		Gas:            1000000,
+		Workers:        1,
+		Loops:          1,
+		TxnsPerLoop:    1,
+		Nonce:          -1,
+		Accounts:       []string{"0x0102030405060708090a0b0c0d0e0f1011121314"},
+	}
+	for _, n := range nodes {
+		e.URLs = append(e.URLs, n.server.URL)
+	}
+	return e
+}
+
+// newTestWorker connects an initialized worker to the nodes of the exerciser
+func newTestWorker(t *testing.T, e *Exerciser) *Worker {
+	endpoints, err := e.dialEndpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(endpoints.Close)
+	w := &Worker{Index: 0, Name: "W0000", Exerciser: e}
+	if err := w.Init(endpoints); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// testMetrics receives the statsd metrics from the exerciser, so the tests can check the counters
+type testMetrics struct {
+	conn     *net.UDPConn
+	mux      sync.Mutex
+	counters map[string]int
+}
+
+func newTestMetrics(t *testing.T, e *Exerciser) *testMetrics {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	e.StatsdServer = conn.LocalAddr().String()
+	if e.metrics, err = statsd.New(statsd.Address(e.StatsdServer), statsd.FlushPeriod(0)); err != nil {
+		t.Fatal(err)
+	}
+	return &testMetrics{conn: conn, counters: make(map[string]int)}
+}
+
+// counts flushes the metrics, and returns the counters for a worker by name, such as tx.success
+func (m *testMetrics) counts(t *testing.T, e *Exerciser, workerName string) map[string]int {
+	e.metrics.Flush()
+	buf := make([]byte, 65536)
+	for {
+		m.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := m.conn.Read(buf)
+		if err != nil {
+			break
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			// Graphite naming: server.P000123W0000.tx.success:1|c
+			parts := strings.SplitN(line, ":", 2)
+			idx := strings.Index(parts[0], workerName+".")
+			if len(parts) != 2 || idx < 0 || !strings.HasSuffix(parts[1], "|c") {
+				continue
+			}
+			count, _ := strconv.Atoi(strings.TrimSuffix(parts[1], "|c"))
+			m.counters[parts[0][idx+len(workerName)+1:]] += count
+		}
+	}
+	return m.counters
+}
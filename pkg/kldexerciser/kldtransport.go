@@ -0,0 +1,157 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+)
+
+// isHTTPURL is true for HTTP(S) URLs. Other URLs are WebSocket (ws/wss) or an IPC socket path
+func isHTTPURL(url string) bool {
+	lower := strings.ToLower(url)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// isWebSocketURL is true for ws:// and wss:// URLs
+func isWebSocketURL(url string) bool {
+	lower := strings.ToLower(url)
+	return strings.HasPrefix(lower, "ws://") || strings.HasPrefix(lower, "wss://")
+}
+
+// tlsConfig builds the TLS configuration for HTTPS and WSS connections, from the CA bundle,
+// client certificate and server name options. It is nil to use the Go defaults
+func (e *Exerciser) tlsConfig() (*tls.Config, error) {
+	if e.TLSCAFile == "" && e.TLSCertFile == "" && e.TLSKeyFile == "" && e.TLSServerName == "" && !e.TLSInsecure {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         e.TLSServerName,
+		InsecureSkipVerify: e.TLSInsecure,
+	}
+	if e.TLSCAFile != "" {
+		caPEM, err := ioutil.ReadFile(e.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle %s: %s", e.TLSCAFile, err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", e.TLSCAFile)
+		}
+	}
+	if (e.TLSCertFile == "") != (e.TLSKeyFile == "") {
+		return nil, fmt.Errorf("a TLS client certificate and key must be specified together")
+	}
+	if e.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(e.TLSCertFile, e.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load TLS client certificate %s: %s", e.TLSCertFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// newHTTPClient creates the HTTP client for JSON/RPC, pooling connections for all the workers
+func newHTTPClient(tlsConfig *tls.Config, auth *authorizer) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(60) * time.Second,
+		KeepAlive: time.Duration(60) * time.Second,
+		DualStack: true,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          500,
+		MaxIdleConnsPerHost:   500,
+		MaxConnsPerHost:       500,
+		IdleConnTimeout:       time.Duration(100) * time.Second,
+		ExpectContinueTimeout: 0, // Send body immediately on the back-end
+		TLSClientConfig:       tlsConfig,
+	}
+	if auth != nil {
+		return &http.Client{
+			Transport: &authTransport{base: transport, authorizer: auth},
+		}
+	}
+	return &http.Client{
+		Transport: transport,
+	}
+}
+
+// newWebSocketDialer creates the dialer for WebSocket connections. go-ethereum v1.10 has
+// no option for handshake headers, so the headers and bearer token are set by the Proxy
+// function, which the gorilla dialer calls with the handshake request before sending it.
+// The token is read for each handshake, including reconnects, so a refreshed token is
+// not sent on an established connection
+func newWebSocketDialer(tlsConfig *tls.Config, auth *authorizer) websocket.Dialer {
+	dialer := websocket.Dialer{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+	}
+	if auth != nil {
+		dialer.Proxy = func(req *http.Request) (*neturl.URL, error) {
+			if err := auth.apply(req.Header); err != nil {
+				return nil, err
+			}
+			return http.ProxyFromEnvironment(req)
+		}
+	}
+	return dialer
+}
+
+// dialRPC connects to the node over HTTP(S), WebSocket or IPC. Connections that
+// support subscriptions (streaming) are used to wait for new blocks, rather than polling
+func (e *Exerciser) dialRPC(url string) (rpcClient *rpc.Client, streaming bool, err error) {
+	tlsConfig, err := e.tlsConfig()
+	if err != nil {
+		return nil, false, err
+	}
+	auth, err := e.getAuthorizer()
+	if err != nil {
+		return nil, false, err
+	}
+	if isHTTPURL(url) {
+		if rpcClient, err = rpc.DialHTTPWithClient(url, newHTTPClient(tlsConfig, auth)); err != nil {
+			return nil, false, fmt.Errorf("connection to %s failed: %s", redactURL(url), err)
+		}
+		return rpcClient, false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(e.RPCTimeout)*time.Second)
+	defer cancel()
+	if isWebSocketURL(url) {
+		dialer := newWebSocketDialer(tlsConfig, auth)
+		rpcClient, err = rpc.DialWebsocketWithDialer(ctx, url, "", dialer)
+	} else {
+		rpcClient, err = rpc.DialContext(ctx, url)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("connection to %s failed: %s", redactURL(url), err)
+	}
+	return rpcClient, true, nil
+}
@@ -0,0 +1,295 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// newTestWebSocketNode serves JSON/RPC over WebSocket, recording the headers of each handshake
+func newTestWebSocketNode(t *testing.T) (url string, handshakes func() []http.Header) {
+	var mux sync.Mutex
+	var headers []http.Header
+	server := rpc.NewServer()
+	handler := server.WebsocketHandler([]string{"*"})
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mux.Lock()
+		headers = append(headers, req.Header.Clone())
+		mux.Unlock()
+		handler.ServeHTTP(w, req)
+	}))
+	t.Cleanup(func() {
+		httpServer.Close()
+		server.Stop()
+	})
+	return "ws" + strings.TrimPrefix(httpServer.URL, "http"), func() []http.Header {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([]http.Header{}, headers...)
+	}
+}
+
+func TestIsWebSocketURL(t *testing.T) {
+	for url, expected := range map[string][2]bool{
+		"http://node:8545":      {true, false},
+		"HTTPS://node":          {true, false},
+		"ws://node:8546":        {false, true},
+		"WSS://node":            {false, true},
+		"/tmp/geth.ipc":         {false, false},
+		"\\\\.\\pipe\\geth.ipc": {false, false},
+	} {
+		if isHTTPURL(url) != expected[0] || isWebSocketURL(url) != expected[1] {
+			t.Errorf("%s: expected http=%t ws=%t", url, expected[0], expected[1])
+		}
+	}
+}
+
+func TestDialRPCStreaming(t *testing.T) {
+	wsURL, _ := newTestWebSocketNode(t)
+	ipcPath := filepath.Join(t.TempDir(), "geth.ipc")
+	listener, err := net.Listen("unix", ipcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	go server.ServeListener(listener)
+	defer server.Stop()
+	defer listener.Close()
+	e := &Exerciser{RPCTimeout: 5}
+	for url, streaming := range map[string]bool{newTestNode(t).server.URL: false, wsURL: true, ipcPath: true} {
+		rpcClient, s, err := e.dialRPC(url)
+		if err != nil {
+			t.Fatalf("%s: %s", url, err)
+		}
+		rpcClient.Close()
+		if s != streaming {
+			t.Errorf("%s: expected streaming=%t", url, streaming)
+		}
+	}
+}
+
+// The handshake headers are set by the dialer's Proxy function, as go-ethereum v1.10 has no
+// option for them. This fails if an upgrade of gorilla or go-ethereum stops them being sent
+func TestDialRPCWebSocketHandshakeHeaders(t *testing.T) {
+	url, handshakes := newTestWebSocketNode(t)
+	t.Setenv("KLD_TEST_TOKEN", "token1")
+	e := &Exerciser{
+		Headers:    []string{"X-Api-Key: key"},
+		TokenEnv:   "KLD_TEST_TOKEN",
+		RPCTimeout: 5,
+	}
+	rpcClient, _, err := e.dialRPC(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.Close()
+	headers := handshakes()
+	if len(headers) != 1 {
+		t.Fatalf("expected 1 handshake, got %d", len(headers))
+	}
+	if headers[0].Get("Authorization") != "Bearer token1" || headers[0].Get("X-Api-Key") != "key" {
+		t.Errorf("unexpected handshake headers %v", headers[0])
+	}
+	// The custom headers do not replace the WebSocket headers
+	if headers[0].Get("Upgrade") != "websocket" {
+		t.Errorf("unexpected handshake headers %v", headers[0])
+	}
+}
+
+func TestWebSocketHandshakeTokenError(t *testing.T) {
+	url, handshakes := newTestWebSocketNode(t)
+	auth := &authorizer{tokenFile: "missing", refresh: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := rpc.DialWebsocketWithDialer(ctx, url, "", newWebSocketDialer(nil, auth)); err == nil || !strings.Contains(err.Error(), "unable to read token file missing") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(handshakes()) != 0 {
+		t.Errorf("handshake sent without a token")
+	}
+}
+
+// testCertificate is a certificate and key, as parsed and as PEM files
+type testCertificate struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCertificate creates a certificate signed by the CA, or a CA if there is none
+func newTestCertificate(t *testing.T, dir, name string, ca *testCertificate, dnsNames ...string) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dnsNames,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(certDER)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{
+		cert:     cert,
+		key:      key,
+		certFile: writeTestFile(t, dir, name+".crt", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})), 0600),
+		keyFile:  writeTestFile(t, dir, name+".key", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})), 0600),
+	}
+}
+
+type testNetService struct{}
+
+func (s *testNetService) Version() string { return "2018" }
+
+// newTestTLSNode serves JSON/RPC over HTTPS and WSS, with a certificate only valid for
+// node.internal, and requiring a client certificate signed by the CA
+func newTestTLSNode(t *testing.T, ca, serverCert *testCertificate) (httpsURL, wssURL string) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("net", &testNetService{}); err != nil {
+		t.Fatal(err)
+	}
+	wsHandler := server.WebsocketHandler([]string{"*"})
+	httpServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			wsHandler.ServeHTTP(w, req)
+			return
+		}
+		server.ServeHTTP(w, req)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	httpServer.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.cert.Raw}, PrivateKey: serverCert.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	httpServer.StartTLS()
+	t.Cleanup(func() {
+		httpServer.Close()
+		server.Stop()
+	})
+	return httpServer.URL, "wss" + strings.TrimPrefix(httpServer.URL, "https")
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, dir, "ca", nil)
+	client := newTestCertificate(t, dir, "client", ca)
+	if config, err := (&Exerciser{}).tlsConfig(); config != nil || err != nil {
+		t.Errorf("expected the default TLS configuration: %v %v", config, err)
+	}
+	config, err := (&Exerciser{TLSCAFile: ca.certFile, TLSCertFile: client.certFile, TLSKeyFile: client.keyFile, TLSServerName: "node.internal"}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 || config.ServerName != "node.internal" || config.InsecureSkipVerify {
+		t.Errorf("unexpected TLS configuration %+v", config)
+	}
+	if config, err := (&Exerciser{TLSInsecure: true}).tlsConfig(); err != nil || !config.InsecureSkipVerify || config.RootCAs != nil {
+		t.Errorf("unexpected TLS configuration %+v: %v", config, err)
+	}
+
+	notPEM := writeTestFile(t, dir, "ca.txt", "not a certificate", 0600)
+	tests := []struct {
+		name string
+		e    *Exerciser
+		err  string
+	}{
+		{"missing CA", &Exerciser{TLSCAFile: filepath.Join(dir, "missing.crt")}, "unable to read CA bundle"},
+		{"empty CA", &Exerciser{TLSCAFile: notPEM}, "no PEM certificates found in CA bundle " + notPEM},
+		{"cert without key", &Exerciser{TLSCertFile: client.certFile}, "a TLS client certificate and key must be specified together"},
+		{"key without cert", &Exerciser{TLSKeyFile: client.keyFile}, "a TLS client certificate and key must be specified together"},
+		{"mismatched key", &Exerciser{TLSCertFile: client.certFile, TLSKeyFile: ca.keyFile}, "unable to load TLS client certificate " + client.certFile},
+	}
+	for _, test := range tests {
+		if _, err := test.e.tlsConfig(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing '%s', got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestDialRPCMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, dir, "ca", nil)
+	serverCert := newTestCertificate(t, dir, "server", ca, "node.internal")
+	client := newTestCertificate(t, dir, "client", ca)
+	httpsURL, wssURL := newTestTLSNode(t, ca, serverCert)
+
+	tests := []struct {
+		name string
+		e    *Exerciser
+		err  string
+	}{
+		{"mutual TLS", &Exerciser{TLSCAFile: ca.certFile, TLSCertFile: client.certFile, TLSKeyFile: client.keyFile, TLSServerName: "node.internal"}, ""},
+		{"insecure", &Exerciser{TLSCertFile: client.certFile, TLSKeyFile: client.keyFile, TLSInsecure: true}, ""},
+		{"unknown CA", &Exerciser{TLSCertFile: client.certFile, TLSKeyFile: client.keyFile, TLSServerName: "node.internal"}, "certificate"},
+		// The certificate is for node.internal, rather than the address we connect to
+		{"no server name", &Exerciser{TLSCAFile: ca.certFile, TLSCertFile: client.certFile, TLSKeyFile: client.keyFile}, "certificate"},
+		{"no client certificate", &Exerciser{TLSCAFile: ca.certFile, TLSServerName: "node.internal"}, "certificate"},
+	}
+	for _, test := range tests {
+		for _, url := range []string{httpsURL, wssURL} {
+			test.e.URLs = []string{url}
+			test.e.RPCTimeout = 5
+			// The network ID is queried with the same TLS configuration as the workers
+			networkID, err := test.e.GetNetworkID()
+			if test.err == "" {
+				if err != nil || networkID != 2018 {
+					t.Errorf("%s %s: unexpected result %d: %v", test.name, url, networkID, err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s %s: expected error containing '%s', got %v", test.name, url, test.err, err)
+			}
+		}
+	}
+}
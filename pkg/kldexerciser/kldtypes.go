@@ -0,0 +1,235 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ConvertArg converts a value supplied on the command line into the Go type
+// expected by the ABI packer for the specified type.
+// Elementary types are supplied as strings. Arrays are supplied as a JSON array,
+// and tuples as a JSON array in component order or a JSON object keyed by
+// component name - either as a string, or already parsed with UseNumber
+func ConvertArg(t abi.Type, val interface{}) (interface{}, error) {
+	v, err := convertArg(t, val)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// ParseJSONArg parses a JSON value, preserving the precision of numbers
+func ParseJSONArg(jsonVal []byte) (interface{}, error) {
+	var val interface{}
+	dec := json.NewDecoder(bytes.NewReader(jsonVal))
+	dec.UseNumber()
+	if err := dec.Decode(&val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+func convertArg(t abi.Type, val interface{}) (reflect.Value, error) {
+	// Allow JSON numbers and booleans for convenience
+	switch jv := val.(type) {
+	case json.Number:
+		val = jv.String()
+	case bool:
+		val = strconv.FormatBool(jv)
+	}
+	switch t.T {
+	case abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return convertComposite(t, val)
+	}
+	strval, ok := val.(string)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("expected a string value for %s: %v", t, val)
+	}
+	switch t.T {
+	case abi.StringTy:
+		return reflect.ValueOf(strval), nil
+	case abi.IntTy, abi.UintTy:
+		return convertInteger(t, strval)
+	case abi.BoolTy:
+		b, err := strconv.ParseBool(strval)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("could not convert '%s' to %s", strval, t)
+		}
+		return reflect.ValueOf(b), nil
+	case abi.AddressTy:
+		if !common.IsHexAddress(strval) {
+			return reflect.Value{}, fmt.Errorf("invalid hex address: %s", strval)
+		}
+		return reflect.ValueOf(common.HexToAddress(strval)), nil
+	case abi.BytesTy:
+		b, err := hexutil.Decode(strval)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("could not convert '%s' to %s: %s", strval, t, err)
+		}
+		return reflect.ValueOf(b), nil
+	case abi.FixedBytesTy, abi.FunctionTy:
+		return convertFixedBytes(t, strval)
+	default:
+		return reflect.Value{}, fmt.Errorf("no string parsing configured yet for type %s", t)
+	}
+}
+
+// convertInteger parses a decimal or 0x prefixed hex integer, checking it fits
+// in the bit size of the type. Sizes up to 64 bits map to native Go integers
+func convertInteger(t abi.Type, strval string) (reflect.Value, error) {
+	i := new(big.Int)
+	ok := false
+	if strings.HasPrefix(strval, "0x") || strings.HasPrefix(strval, "0X") {
+		_, ok = i.SetString(strval[2:], 16)
+	} else {
+		_, ok = i.SetString(strval, 10)
+	}
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("could not convert '%s' to %s", strval, t)
+	}
+
+	var min, max *big.Int
+	if t.T == abi.UintTy {
+		min = big.NewInt(0)
+		max = new(big.Int).Lsh(big.NewInt(1), uint(t.Size))
+	} else {
+		max = new(big.Int).Lsh(big.NewInt(1), uint(t.Size-1))
+		min = new(big.Int).Neg(max)
+	}
+	if i.Cmp(min) < 0 || i.Cmp(max) >= 0 {
+		return reflect.Value{}, fmt.Errorf("value '%s' out of range for %s", strval, t)
+	}
+
+	goType := t.GetType()
+	switch goType.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.ValueOf(i.Int64()).Convert(goType), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return reflect.ValueOf(i.Uint64()).Convert(goType), nil
+	default:
+		return reflect.ValueOf(i), nil
+	}
+}
+
+// convertFixedBytes parses a hex string into a fixed size byte array.
+// Shorter values are right padded with zeros, as in Solidity
+func convertFixedBytes(t abi.Type, strval string) (reflect.Value, error) {
+	b, err := hexutil.Decode(strval)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("could not convert '%s' to %s: %s", strval, t, err)
+	}
+	goType := t.GetType()
+	if len(b) > goType.Len() {
+		return reflect.Value{}, fmt.Errorf("value '%s' is %d bytes, which is too long for %s", strval, len(b), t)
+	}
+	v := reflect.New(goType).Elem()
+	reflect.Copy(v, reflect.ValueOf(b))
+	return v, nil
+}
+
+// convertComposite builds arrays, slices and tuple structs from JSON values
+func convertComposite(t abi.Type, val interface{}) (reflect.Value, error) {
+	if strval, ok := val.(string); ok {
+		parsed, err := ParseJSONArg([]byte(strval))
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("expected a JSON value for %s: %s", t, err)
+		}
+		val = parsed
+	}
+	if t.T == abi.TupleTy {
+		return convertTuple(t, val)
+	}
+	list, ok := val.([]interface{})
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("expected a JSON array for %s: %v", t, val)
+	}
+
+	var v reflect.Value
+	if t.T == abi.ArrayTy {
+		if len(list) != t.Size {
+			return reflect.Value{}, fmt.Errorf("%s requires %d values (%d supplied)", t, t.Size, len(list))
+		}
+		v = reflect.New(t.GetType()).Elem()
+	} else {
+		v = reflect.MakeSlice(t.GetType(), len(list), len(list))
+	}
+	for i := range list {
+		elem, err := convertArg(*t.Elem, list[i])
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s[%d]: %s", t, i, err)
+		}
+		v.Index(i).Set(elem)
+	}
+	return v, nil
+}
+
+// convertTuple builds the struct for a tuple from a JSON array of values in
+// component order, or a JSON object that must contain exactly the components
+func convertTuple(t abi.Type, val interface{}) (reflect.Value, error) {
+	var list []interface{}
+	switch tv := val.(type) {
+	case []interface{}:
+		if len(tv) != len(t.TupleElems) {
+			return reflect.Value{}, fmt.Errorf("%s requires %d values (%d supplied)", t, len(t.TupleElems), len(tv))
+		}
+		list = tv
+	case map[string]interface{}:
+		list = make([]interface{}, len(t.TupleRawNames))
+		for i, name := range t.TupleRawNames {
+			fieldVal, ok := tv[name]
+			if !ok {
+				return reflect.Value{}, fmt.Errorf("%s missing field '%s'", t, name)
+			}
+			list[i] = fieldVal
+		}
+		for name := range tv {
+			if !containsString(t.TupleRawNames, name) {
+				return reflect.Value{}, fmt.Errorf("%s has no field '%s' (fields: %s)", t, name, t.TupleRawNames)
+			}
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("expected a JSON array or object for %s: %v", t, val)
+	}
+
+	v := reflect.New(t.GetType()).Elem()
+	for i, elemType := range t.TupleElems {
+		elem, err := convertArg(*elemType, list[i])
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s.%s: %s", t, t.TupleRawNames[i], err)
+		}
+		v.Field(i).Set(elem)
+	}
+	return v, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, entry := range list {
+		if entry == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func mustNewType(t *testing.T, typeName string, components []abi.ArgumentMarshaling) abi.Type {
+	abiType, err := abi.NewType(typeName, "", components)
+	if err != nil {
+		t.Fatalf("abi.NewType(%s): %s", typeName, err)
+	}
+	return abiType
+}
+
+func bigString(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic(s)
+	}
+	return i
+}
+
+func TestConvertArgElementary(t *testing.T) {
+	tests := []struct {
+		typeName string
+		val      interface{}
+		expected interface{}
+		err      string
+	}{
+		{"uint8", "0", uint8(0), ""},
+		{"uint8", "255", uint8(255), ""},
+		{"uint8", "256", nil, "out of range"},
+		{"uint8", "-1", nil, "out of range"},
+		{"int8", "127", int8(127), ""},
+		{"int8", "-128", int8(-128), ""},
+		{"int8", "128", nil, "out of range"},
+		{"int8", "-129", nil, "out of range"},
+		{"uint64", "18446744073709551615", uint64(18446744073709551615), ""},
+		{"uint64", "18446744073709551616", nil, "out of range"},
+		{"int64", "-9223372036854775808", int64(-9223372036854775808), ""},
+		{"uint24", "0xffffff", nil, ""},
+		{"uint24", "0x1000000", nil, "out of range"},
+		{"uint256", "115792089237316195423570985008687907853269984665640564039457584007913129639935",
+			bigString("115792089237316195423570985008687907853269984665640564039457584007913129639935"), ""},
+		{"uint256", "115792089237316195423570985008687907853269984665640564039457584007913129639936", nil, "out of range"},
+		{"int256", "-57896044618658097711785492504343953926634992332820282019728792003956564819968",
+			bigString("-57896044618658097711785492504343953926634992332820282019728792003956564819968"), ""},
+		{"int256", "57896044618658097711785492504343953926634992332820282019728792003956564819968", nil, "out of range"},
+		{"uint256", "0X0a", big.NewInt(10), ""},
+		{"uint256", "12abc", nil, "could not convert"},
+		{"uint256", "1.5", nil, "could not convert"},
+		{"bool", "true", true, ""},
+		{"bool", true, true, ""},
+		{"bool", "yes", nil, "could not convert"},
+		{"string", "hello", "hello", ""},
+		{"string", 12, nil, "expected a string value"},
+		{"address", "0x0102030405060708090a0b0c0d0e0f1011121314", common.HexToAddress("0x0102030405060708090a0b0c0d0e0f1011121314"), ""},
+		{"address", "0x0102", nil, "invalid hex address"},
+		{"bytes", "0x0102", []byte{1, 2}, ""},
+		{"bytes", "0x", []byte{}, ""},
+		{"bytes", "0102", nil, "could not convert"},
+		{"bytes4", "0x01020304", [4]byte{1, 2, 3, 4}, ""},
+		{"bytes4", "0x0102", [4]byte{1, 2, 0, 0}, ""},
+		{"bytes4", "0x0102030405", nil, "too long"},
+		{"bytes32", "0x01", [32]byte{1}, ""},
+	}
+	for _, test := range tests {
+		name := fmt.Sprintf("%s(%v)", test.typeName, test.val)
+		t.Run(name, func(t *testing.T) {
+			v, err := ConvertArg(mustNewType(t, test.typeName, nil), test.val)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing '%s', got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.expected != nil && !reflect.DeepEqual(v, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, v)
+			}
+		})
+	}
+}
+
+func TestConvertArgIntegerTypes(t *testing.T) {
+	// The ABI packer requires the exact Go type for each integer size
+	for typeName, kind := range map[string]reflect.Kind{
+		"uint8":   reflect.Uint8,
+		"uint16":  reflect.Uint16,
+		"uint24":  reflect.Ptr,
+		"uint32":  reflect.Uint32,
+		"uint64":  reflect.Uint64,
+		"uint128": reflect.Ptr,
+		"int8":    reflect.Int8,
+		"int32":   reflect.Int32,
+		"int64":   reflect.Int64,
+		"int256":  reflect.Ptr,
+	} {
+		v, err := ConvertArg(mustNewType(t, typeName, nil), "1")
+		if err != nil {
+			t.Fatalf("%s: %s", typeName, err)
+		}
+		if reflect.TypeOf(v).Kind() != kind {
+			t.Errorf("%s: expected %s, got %T", typeName, kind, v)
+		}
+	}
+}
+
+func TestConvertArgJSONNumberPrecision(t *testing.T) {
+	// Numbers beyond the precision of a float64 must not be rounded
+	val, err := ParseJSONArg([]byte(`[12345678901234567890123, 9007199254740993]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ConvertArg(mustNewType(t, "uint256[]", nil), val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*big.Int{bigString("12345678901234567890123"), bigString("9007199254740993")}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("expected %v, got %v", expected, v)
+	}
+
+	v, err = ConvertArg(mustNewType(t, "uint64[2]", nil), "[18446744073709551615, 0]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, [2]uint64{18446744073709551615, 0}) {
+		t.Errorf("unexpected %v", v)
+	}
+}
+
+func TestConvertArgArrays(t *testing.T) {
+	tests := []struct {
+		typeName string
+		val      string
+		expected interface{}
+		err      string
+	}{
+		{"uint8[]", `[1, "2", "0x03"]`, []uint8{1, 2, 3}, ""},
+		{"uint8[]", `[]`, []uint8{}, ""},
+		{"uint8[2]", `[1, 2]`, [2]uint8{1, 2}, ""},
+		{"uint8[2]", `[1]`, nil, "requires 2 values (1 supplied)"},
+		{"uint8[]", `[1, 256]`, nil, "uint8[][1]: value '256' out of range"},
+		{"bool[]", `[true, "false"]`, []bool{true, false}, ""},
+		{"string[]", `["a", "b"]`, []string{"a", "b"}, ""},
+		{"uint8[][]", `[[1], [2, 3]]`, [][]uint8{{1}, {2, 3}}, ""},
+		{"uint8[]", `{"a": 1}`, nil, "expected a JSON array"},
+		{"uint8[]", `[1,`, nil, "expected a JSON value"},
+	}
+	for _, test := range tests {
+		t.Run(test.typeName+test.val, func(t *testing.T) {
+			v, err := ConvertArg(mustNewType(t, test.typeName, nil), test.val)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing '%s', got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(v, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, v)
+			}
+		})
+	}
+}
+
+func TestConvertArgTuples(t *testing.T) {
+	tupleType := mustNewType(t, "tuple", []abi.ArgumentMarshaling{
+		{Name: "id", Type: "uint256"},
+		{Name: "owner", Type: "address"},
+		{Name: "inner", Type: "tuple", Components: []abi.ArgumentMarshaling{
+			{Name: "flag", Type: "bool"},
+			{Name: "tags", Type: "string[]"},
+		}},
+	})
+	owner := "0x0102030405060708090a0b0c0d0e0f1011121314"
+	tests := []struct {
+		name string
+		val  string
+		err  string
+	}{
+		{"array", `[1, "` + owner + `", [true, ["a", "b"]]]`, ""},
+		{"object", `{"id": 1, "owner": "` + owner + `", "inner": {"flag": true, "tags": ["a", "b"]}}`, ""},
+		{"mixed", `{"id": "1", "owner": "` + owner + `", "inner": [true, ["a", "b"]]}`, ""},
+		{"short array", `[1, "` + owner + `"]`, "requires 3 values (2 supplied)"},
+		{"missing field", `{"id": 1, "inner": [true, []]}`, "missing field 'owner'"},
+		{"extra field", `{"id": 1, "owner": "` + owner + `", "inner": [true, []], "other": 1}`, "has no field 'other'"},
+		{"bad nested", `[1, "` + owner + `", {"flag": "maybe", "tags": []}]`, ".inner: "},
+		{"scalar", `1`, "expected a JSON array or object"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := ConvertArg(tupleType, test.val)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing '%s', got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			rv := reflect.ValueOf(v)
+			if id := rv.Field(0).Interface().(*big.Int); id.Int64() != 1 {
+				t.Errorf("unexpected id %s", id)
+			}
+			if rv.Field(1).Interface().(common.Address) != common.HexToAddress(owner) {
+				t.Errorf("unexpected owner %v", rv.Field(1))
+			}
+			inner := rv.Field(2)
+			if !inner.Field(0).Bool() || !reflect.DeepEqual(inner.Field(1).Interface(), []string{"a", "b"}) {
+				t.Errorf("unexpected inner %v", inner)
+			}
+			// The result must be packable with the type it was converted for
+			args := abi.Arguments{{Type: tupleType}}
+			if _, err := args.Pack(v); err != nil {
+				t.Errorf("pack failed: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	log "github.com/sirupsen/logrus"
+)
+
+// stripMetadata removes the CBOR encoded metadata the compiler appends to the code.
+// It contains the hash of the metadata, so differs between otherwise identical builds.
+// The last two bytes are the length of the CBOR, which starts with a map (0xa0-0xbf)
+func stripMetadata(code []byte) []byte {
+	if len(code) < 2 {
+		return code
+	}
+	length := int(code[len(code)-2])<<8 | int(code[len(code)-1])
+	start := len(code) - 2 - length
+	if length == 0 || start < 0 || code[start]&0xe0 != 0xa0 {
+		return code
+	}
+	return code[:start]
+}
+
+// runtimeCodeToVerify decodes the compiled runtime code, along with the ranges that are
+// expected to differ once deployed: library addresses, and immutables
+func (c *CompiledSolidity) runtimeCodeToVerify() ([]byte, []CodeRange, error) {
+	code := strings.TrimPrefix(c.RuntimeCode, "0x")
+	if code == "" {
+		return nil, nil, fmt.Errorf("no runtime code available to verify against")
+	}
+	var ignored []CodeRange
+	for {
+		idx := strings.Index(code, "__")
+		if idx < 0 {
+			break
+		}
+		if idx%2 != 0 || idx+40 > len(code) {
+			return nil, nil, fmt.Errorf("invalid library placeholder in the runtime code")
+		}
+		code = code[:idx] + strings.Repeat("0", 40) + code[idx+40:]
+		ignored = append(ignored, CodeRange{Start: idx / 2, Length: 20})
+	}
+	compiled, err := hex.DecodeString(code)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid runtime code: %s", err)
+	}
+	return compiled, append(ignored, c.Immutables...), nil
+}
+
+// immutablesUnknown is true for Solidity contracts loaded from artifacts that do not record
+// the immutable references, such as Hardhat and Truffle artifacts. Vyper appends immutables
+// to the end of the code instead
+func (c *CompiledSolidity) immutablesUnknown() bool {
+	return c.Immutables == nil && c.ContractInfo.Language != "Vyper"
+}
+
+// VerifyRuntimeCode checks the code deployed at an address is the compiled contract,
+// ignoring the metadata hash, linked library addresses and immutables.
+// Without the immutable references the code is compared in full
+func (c *CompiledSolidity) VerifyRuntimeCode(deployed []byte) error {
+	if len(deployed) == 0 {
+		return fmt.Errorf("no contract code is deployed")
+	}
+	compiled, ignored, err := c.runtimeCodeToVerify()
+	if err != nil {
+		return err
+	}
+	compiled = stripMetadata(compiled)
+	if c.ContractInfo.Language == "Vyper" {
+		// Vyper appends the values of immutables to the deployed code
+		if len(deployed) < len(compiled) {
+			return fmt.Errorf("deployed code is %d bytes, compiled runtime code is %d bytes", len(deployed), len(compiled))
+		}
+		deployed = deployed[:len(compiled)]
+	} else if deployed = stripMetadata(deployed); len(deployed) != len(compiled) {
+		return fmt.Errorf("deployed code is %d bytes, compiled runtime code is %d bytes (excluding metadata)", len(deployed), len(compiled))
+	}
+	ignore := make([]bool, len(compiled))
+	for _, r := range ignored {
+		for i := r.Start; i < r.Start+r.Length && i < len(ignore); i++ {
+			ignore[i] = true
+		}
+	}
+	for i := range compiled {
+		if !ignore[i] && deployed[i] != compiled[i] {
+			if c.immutablesUnknown() {
+				return fmt.Errorf("deployed code differs from the compiled runtime code at byte %d (the immutable references are not known, so immutables cannot be ignored)", i)
+			}
+			return fmt.Errorf("deployed code differs from the compiled runtime code at byte %d", i)
+		}
+	}
+	return nil
+}
+
+// verifyDeployedCode fetches the code at the contract address, and verifies it against the compiled contract
+func (w *Worker) verifyDeployedCode() error {
+	if w.CompiledContract.immutablesUnknown() {
+		log.Warnf("The contract artifact has no immutable references, so the deployed code of %s must match exactly. Contracts with immutables will fail verification. Compile the source, or use a Foundry or exported artifact, to ignore immutables", w.Exerciser.To.Hex())
+	}
+	var code hexutil.Bytes
+	if err := w.rpcCall(&code, "eth_getCode", w.Exerciser.To.Hex(), "latest"); err != nil {
+		return fmt.Errorf("eth_getCode failed: %s", err)
+	}
+	if err := w.CompiledContract.VerifyRuntimeCode(code); err != nil {
+		return fmt.Errorf("contract at %s does not match the compiled contract: %s", w.Exerciser.To.Hex(), err)
+	}
+	w.info("Verified deployed code at %s matches the compiled contract", w.Exerciser.To.Hex())
+	return nil
+}
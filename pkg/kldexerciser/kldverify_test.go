@@ -0,0 +1,136 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/compiler"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// testMetadata is CBOR metadata as appended by solc, with its two byte length
+var testMetadata = []byte{0xa2, 0x64, 'i', 'p', 'f', 's', 0x42, 0x12, 0x34, 0x00, 0x09}
+
+func TestStripMetadata(t *testing.T) {
+	code := []byte{0x60, 0x80, 0x60, 0x40}
+	if stripped := stripMetadata(append(append([]byte{}, code...), testMetadata...)); !bytes.Equal(stripped, code) {
+		t.Errorf("metadata not stripped: %x", stripped)
+	}
+	for _, unchanged := range [][]byte{code, {0x01}, {0x60, 0x00, 0x01}, {0x60, 0x80, 0x00, 0x00}} {
+		if stripped := stripMetadata(unchanged); !bytes.Equal(stripped, unchanged) {
+			t.Errorf("%x: unexpected %x", unchanged, stripped)
+		}
+	}
+}
+
+// testImmutableCode has a 32 byte immutable, left as zeros by solc, at offset 3
+func testImmutableCode(immutable byte) []byte {
+	word := make([]byte, 32)
+	word[31] = immutable
+	return bytes.Join([][]byte{{0x60, 0x80, 0x7f}, word, {0x60, 0x00, 0xf3}}, nil)
+}
+
+func TestVerifyRuntimeCode(t *testing.T) {
+	compiledCode := "0x" + hex.EncodeToString(append(testImmutableCode(0), testMetadata...))
+	deployedCode := append(testImmutableCode(42), 0xa1, 0x00, 0x01)
+	immutables := []CodeRange{{Start: 3, Length: 32}}
+	tests := []struct {
+		name       string
+		immutables []CodeRange
+		deployed   []byte
+		err        string
+	}{
+		{"immutable ignored", immutables, deployedCode, ""},
+		{"no immutables", []CodeRange{}, deployedCode, "differs from the compiled runtime code at byte 34"},
+		{"unknown immutables", nil, deployedCode, "the immutable references are not known"},
+		{"unknown immutables match", nil, testImmutableCode(0), ""},
+		{"code differs", immutables, append([]byte{0x60, 0x81}, deployedCode[2:]...), "at byte 1"},
+		{"length differs", immutables, deployedCode[:10], "deployed code is 10 bytes"},
+		{"nothing deployed", immutables, nil, "no contract code is deployed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &CompiledSolidity{
+				RuntimeCode:  compiledCode,
+				Immutables:   test.immutables,
+				ContractInfo: compiler.ContractInfo{Language: "Solidity"},
+			}
+			err := c.VerifyRuntimeCode(test.deployed)
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected error containing '%s', got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyRuntimeCodeLibrariesAndVyper(t *testing.T) {
+	lib := common.HexToAddress("0x0102030405060708090a0b0c0d0e0f1011121314")
+	c := &CompiledSolidity{
+		RuntimeCode:  "0x6080" + "73" + mathPlaceholder + "f3",
+		Immutables:   []CodeRange{},
+		ContractInfo: compiler.ContractInfo{Language: "Solidity"},
+	}
+	if err := c.VerifyRuntimeCode(bytes.Join([][]byte{{0x60, 0x80, 0x73}, lib[:], {0xf3}}, nil)); err != nil {
+		t.Errorf("linked library not ignored: %s", err)
+	}
+
+	// Vyper appends the immutables after the runtime code
+	c = &CompiledSolidity{RuntimeCode: "0x60806040", ContractInfo: compiler.ContractInfo{Language: "Vyper"}}
+	if c.immutablesUnknown() {
+		t.Errorf("immutables unknown for Vyper")
+	}
+	if err := c.VerifyRuntimeCode([]byte{0x60, 0x80, 0x60, 0x40, 0x00, 0x2a}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestVerifyDeployedCodeWarnsWithoutImmutables(t *testing.T) {
+	node := newTestNode(t)
+	node.code = testImmutableCode(0)
+	e := newTestExerciser(node)
+	to := common.HexToAddress("0xc0de")
+	e.To = &to
+	w := newTestWorker(t, e)
+	logger, hook := logtest.NewNullLogger()
+	log.StandardLogger().ReplaceHooks(logger.Hooks)
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	for _, immutables := range [][]CodeRange{nil, {}} {
+		hook.Reset()
+		w.CompiledContract = &CompiledSolidity{
+			RuntimeCode:  "0x" + hex.EncodeToString(testImmutableCode(0)),
+			Immutables:   immutables,
+			ContractInfo: compiler.ContractInfo{Language: "Solidity"},
+		}
+		if err := w.verifyDeployedCode(); err != nil {
+			t.Fatal(err)
+		}
+		warned := false
+		for _, entry := range hook.AllEntries() {
+			warned = warned || (entry.Level == log.WarnLevel && strings.Contains(entry.Message, "no immutable references"))
+		}
+		if warned != (immutables == nil) {
+			t.Errorf("immutables %v: warned=%t", immutables, warned)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/compiler"
+	log "github.com/sirupsen/logrus"
+)
+
+// vyperContract is the output for each file in vyper -f combined_json
+type vyperContract struct {
+	ABI               interface{}       `json:"abi"`
+	Bytecode          string            `json:"bytecode"`
+	BytecodeRuntime   string            `json:"bytecode_runtime"`
+	MethodIdentifiers map[string]string `json:"method_identifiers"`
+	UserDoc           interface{}       `json:"userdoc"`
+	DevDoc            interface{}       `json:"devdoc"`
+}
+
+// compileVyper uses vyper to compile the Vyper source into the same structure as Solidity.
+// A Vyper file is a single contract, named after the file
+func compileVyper(vyperFile string, opts *CompilerOptions, contractName, method string, args []interface{}) (*CompiledSolidity, error) {
+	name := strings.TrimSuffix(filepath.Base(vyperFile), filepath.Ext(vyperFile))
+	if contractName != "" && contractName != name {
+		return nil, fmt.Errorf("contract %s not found in Vyper file %s, which contains the single contract %s", contractName, vyperFile, name)
+	}
+	vyperPath := opts.VyperPath
+	if vyperPath == "" {
+		vyperPath = "vyper"
+	}
+	vyperVer, err := getCompilerVersion("vyper", vyperPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find vyper version: %s", err)
+	}
+	source, err := ioutil.ReadFile(vyperFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", vyperFile, err)
+	}
+
+	// Only an EVM version that was specified is passed, as the versions vyper
+	// supports change between releases
+	vyperArgs := []string{"-f", "combined_json"}
+	if opts.EVMVersion != "" {
+		vyperArgs = append(vyperArgs, "--evm-version", opts.EVMVersion)
+	}
+	vyperArgs = append(vyperArgs, vyperFile)
+	vyperOptionsString := strings.Join(append([]string{vyperVer.Path}, vyperArgs...), " ")
+	log.Debugf("Compiling: %s", vyperOptionsString)
+	cmd := exec.Command(vyperVer.Path, vyperArgs...)
+
+	var stderr, stdout bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to compile [%s]: %s", err, stderr.String())
+	}
+
+	// The output is keyed by file, alongside the compiler version
+	var output map[string]json.RawMessage
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("failed to parse vyper output: %s", err)
+	}
+	var info *vyperContract
+	for key, value := range output {
+		if key == "version" {
+			continue
+		}
+		info = &vyperContract{}
+		if err := json.Unmarshal(value, info); err != nil {
+			return nil, fmt.Errorf("failed to parse vyper output for %s: %s", key, err)
+		}
+	}
+	if info == nil {
+		return nil, fmt.Errorf("no contract in vyper output for %s", vyperFile)
+	}
+
+	contract := &compiler.Contract{
+		Code:        prefixHex(info.Bytecode),
+		RuntimeCode: prefixHex(info.BytecodeRuntime),
+		Hashes:      info.MethodIdentifiers,
+		Info: compiler.ContractInfo{
+			Source:          string(source),
+			Language:        "Vyper",
+			LanguageVersion: vyperVer.Version,
+			CompilerVersion: vyperVer.Version,
+			CompilerOptions: vyperOptionsString,
+			AbiDefinition:   info.ABI,
+			UserDoc:         info.UserDoc,
+			DeveloperDoc:    info.DevDoc,
+		},
+	}
+	c, err := newCompiledSolidity(contract, method, args)
+	if err != nil {
+		return nil, err
+	}
+	c.Name = name
+	return c, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testVyperOutput = `{
+  "version": "0.3.10",
+  "%s": {
+    "abi": [{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "x", "type": "uint256"}], "outputs": []}],
+    "bytecode": "0x6003",
+    "bytecode_runtime": "0x6004",
+    "method_identifiers": {"set(uint256)": "0x60fe47b1"}
+  }
+}`
+
+// fakeVyper writes a script that behaves like vyper, recording the arguments it is run with
+func fakeVyper(t *testing.T, vyperFile string) (vyperPath, argsFile string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake vyper is a shell script")
+	}
+	dir := t.TempDir()
+	argsFile = filepath.Join(dir, "args")
+	writeTestFile(t, dir, "output.json", strings.Replace(testVyperOutput, "%s", vyperFile, 1), 0600)
+	vyperPath = writeTestFile(t, dir, "vyper", `#!/bin/sh
+if [ "$1" = "--version" ]; then echo "0.3.10+commit.91361694"; exit 0; fi
+echo "$@" > "`+argsFile+`"
+cat "`+filepath.Join(dir, "output.json")+`"
+`, 0755)
+	return vyperPath, argsFile
+}
+
+func TestCompileVyperEVMVersion(t *testing.T) {
+	vyperFile := writeTestFile(t, t.TempDir(), "Storage.vy", "# @version ^0.3.0\n", 0600)
+	vyperPath, argsFile := fakeVyper(t, vyperFile)
+
+	for _, test := range []struct {
+		evmVersion string
+		expected   string
+	}{
+		// Without an EVM version, the vyper default is used rather than the solc default
+		{"", "-f combined_json " + vyperFile},
+		{"shanghai", "-f combined_json --evm-version shanghai " + vyperFile},
+	} {
+		opts := &CompilerOptions{VyperPath: vyperPath, EVMVersion: test.evmVersion}
+		c, err := CompileContract(vyperFile, opts, "", "set", []interface{}{"1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		args, _ := ioutil.ReadFile(argsFile)
+		if strings.TrimSpace(string(args)) != test.expected {
+			t.Errorf("unexpected vyper args '%s'", strings.TrimSpace(string(args)))
+		}
+		if c.Name != "Storage" || c.Compiled != "0x6003" || c.RuntimeCode != "0x6004" || c.Method.Name != "set" {
+			t.Errorf("unexpected compiled contract: %+v", c)
+		}
+	}
+}
+
+func TestCompileVyperContractName(t *testing.T) {
+	vyperFile := writeTestFile(t, t.TempDir(), "Storage.vy", "# @version ^0.3.0\n", 0600)
+	vyperPath, _ := fakeVyper(t, vyperFile)
+	opts := &CompilerOptions{VyperPath: vyperPath}
+	if _, err := CompileContract(vyperFile, opts, "Storage", "set", []interface{}{"1"}); err != nil {
+		t.Errorf("contract name matching the file rejected: %s", err)
+	}
+	if _, err := CompileContract(vyperFile, opts, "Other", "set", []interface{}{"1"}); err == nil || !strings.Contains(err.Error(), "single contract Storage") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSolcEVMVersionDefault(t *testing.T) {
+	if v := (&CompilerOptions{}).solcEVMVersion(); v != "byzantium" {
+		t.Errorf("unexpected default %s", v)
+	}
+	input := newSolcInput("Test.sol", nil, &CompilerOptions{EVMVersion: "london"})
+	if input.Settings.EVMVersion != "london" {
+		t.Errorf("unexpected EVM version %s", input.Settings.EVMVersion)
+	}
+}
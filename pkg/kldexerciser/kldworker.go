@@ -19,8 +19,10 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math/big"
+	"math/rand"
 	"os"
 	"strings"
 	"sync/atomic"
@@ -30,6 +32,7 @@ import (
 	hexutil "github.com/ethereum/go-ethereum/common/hexutil"
 
 	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	ecrypto "github.com/ethereum/go-ethereum/crypto"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -47,7 +50,6 @@ type Worker struct {
 	Exerciser             *Exerciser
 	Nonce                 uint64
 	CompiledContract      *CompiledSolidity
-	RPC                   *rpc.Client
 	Account               common.Address
 	PrivateKey            *ecdsa.PrivateKey
 	Signer                types.EIP155Signer
@@ -56,6 +58,10 @@ type Worker struct {
 	telegrafMetricsFormat bool
 	metricsQualifier      string
 	lastMiningTime        time.Duration
+	rand                  *rand.Rand
+	endpoints             *endpointPool
+	heads                 chan *newHead
+	headSub               *rpc.ClientSubscription
 }
 
 func (w Worker) debug(message string, inserts ...interface{}) {
@@ -70,18 +76,26 @@ func (w Worker) error(message string, inserts ...interface{}) {
 	log.Error(fmt.Sprintf("%s/L%04d/N%06d: ", w.Name, w.LoopIndex, w.Nonce), fmt.Sprintf(message, inserts...))
 }
 
-// generateTransaction creates a new transaction for the specified data
-func (w *Worker) generateTransaction() *types.Transaction {
+// generateTransaction creates a new transaction for the method,
+// evaluating any arg templates for this transaction
+func (w *Worker) generateTransaction(m *workloadMethod) (*types.Transaction, error) {
+	data := m.PackedCall
+	if m.ArgTemplates != nil {
+		var err error
+		if data, err = w.packTemplatedCall(m); err != nil {
+			return nil, fmt.Errorf("failed to generate args: %s", err)
+		}
+	}
 	tx := types.NewTransaction(
 		w.Nonce,
 		*w.Exerciser.To,
 		big.NewInt(w.Exerciser.Amount),
 		uint64(w.Exerciser.Gas),
 		big.NewInt(w.Exerciser.GasPrice),
-		w.CompiledContract.PackedCall)
-	w.debug("TX:%s To=%s Amount=%d Gas=%d GasPrice=%d",
-		tx.Hash().Hex(), tx.To().Hex(), w.Exerciser.Amount, w.Exerciser.Gas, w.Exerciser.GasPrice)
-	return tx
+		data)
+	w.debug("TX:%s To=%s Method=%s Amount=%d Gas=%d GasPrice=%d",
+		tx.Hash().Hex(), tx.To().Hex(), m.Method.Name, w.Exerciser.Amount, w.Exerciser.Gas, w.Exerciser.GasPrice)
+	return tx, nil
 }
 
 // sendTransaction sends an individual transaction, choosing external or internal signing
@@ -190,36 +204,60 @@ func (w *Worker) emitTiming(name string, timing time.Duration) {
 	}
 }
 
+// rpcCall invokes a JSON/RPC method, retrying when rate limited, and moving to another
+// node when a node fails. The retries all complete within the RPC timeout
 func (w *Worker) rpcCall(result interface{}, method string, args ...interface{}) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.Exerciser.RPCTimeout)*time.Second)
 	defer cancel()
 
+	var lastErr error
 	err := retry.Do(
 		func() error {
-			log.Debugf("Invoking %s", method)
+			// Each attempt picks a node, so a retry can move to another node if this one fails
+			ep := w.endpoints.pick(w.Index)
+			log.Debugf("Invoking %s on %s", method, ep.Name)
 			w.incrCounter("rpc.calls")
-			err := w.RPC.CallContext(ctx, result, method, args...)
+			w.incrEndpointCounter(ep, "rpc.calls")
+			start := time.Now()
+			err := redactError(ep.RPC.CallContext(ctx, result, method, args...))
+			callTime := time.Since(start)
+			ep.record(err, callTime)
+			w.emitEndpointTiming(ep, "rpc.time", callTime)
 			if err == nil {
 				w.incrCounter("rpc.success")
+				w.incrEndpointCounter(ep, "rpc.success")
 			} else {
 				w.incrCounter("rpc.fail")
+				w.incrEndpointCounter(ep, "rpc.fail")
+				lastErr = err
 			}
 			return err
 		},
 		retry.RetryIf(func(err error) bool {
-			return strings.Contains(err.Error(), "429")
+			if strings.Contains(err.Error(), "429") {
+				return true
+			}
+			if len(w.endpoints.endpoints) <= 1 || !isEndpointFailure(err) {
+				return false
+			}
+			// A transaction the node might have received is not sent again, to avoid submitting it twice
+			return !sendMethods[method] || isUndelivered(err)
 		}),
 		retry.Attempts(10),
+		retry.Context(ctx),
 		retry.DelayType(func(n uint, err error, config *retry.Config) time.Duration {
 			log.Debugf("%s attempt %d failed: %s", method, n, err)
 			return retry.BackOffDelay(n, err, config)
 		}),
 	)
+	if err == context.DeadlineExceeded && lastErr != nil {
+		return fmt.Errorf("%s timed out after %ds retrying: %s", method, w.Exerciser.RPCTimeout, lastErr)
+	}
 	return err
 }
 
 // callContract call a transaction and return the result as a string
-func (w *Worker) callContract(tx *types.Transaction) (err error) {
+func (w *Worker) callContract(m *workloadMethod, tx *types.Transaction) (values DecodedValues, err error) {
 
 	start := time.Now()
 
@@ -243,15 +281,51 @@ func (w *Worker) callContract(tx *types.Transaction) (err error) {
 		var retValue string
 		err = w.rpcCall(&retValue, "eth_call", args, "latest")
 		callTime := time.Since(start)
-		w.info("call result: '%s' [%.2fs]", retValue, callTime.Seconds())
+		w.emitMethodTiming(m, "call.time", callTime)
+		if err == nil {
+			w.incrMethodCounter(m, "call.success")
+			values = w.reportCallResult(m.Method, retValue, callTime)
+		} else {
+			w.incrMethodCounter(m, "call.fail")
+		}
 	}
 	if err != nil {
-		return fmt.Errorf("contract call failed: %s", err)
+		if reason := w.revertReason(err); reason != nil {
+			w.incrCounter("call.revert." + reason.Name)
+			return nil, fmt.Errorf("contract call reverted: %s", reason)
+		}
+		return nil, fmt.Errorf("contract call failed: %s", err)
 	}
 
 	return
 }
 
+// reportCallResult decodes the result of a call against the outputs of the method
+func (w *Worker) reportCallResult(method *abi.Method, retValue string, callTime time.Duration) DecodedValues {
+	if len(method.Outputs) == 0 {
+		w.info("call result: '%s' [%.2fs]", retValue, callTime.Seconds())
+		return nil
+	}
+	data, err := hexutil.Decode(retValue)
+	if err == nil && len(data) == 0 {
+		err = fmt.Errorf("no data returned")
+	}
+	var values DecodedValues
+	if err == nil {
+		values, err = DecodeValues(method.Outputs, data)
+	}
+	if err != nil {
+		w.error("call result: '%s' could not be decoded: %s [%.2fs]", retValue, err, callTime.Seconds())
+		return nil
+	}
+	w.info("call result: %s [%.2fs]", values, callTime.Seconds())
+	if w.Exerciser.CallOutput == "json" {
+		jsonBytes, _ := json.Marshal(values)
+		fmt.Println(string(jsonBytes))
+	}
+	return values
+}
+
 // signAndSendTxn externally signs and sends a transaction
 func (w *Worker) signAndSendTxn(tx *types.Transaction) (string, error) {
 	signedTx, _ := types.SignTx(tx, w.Signer, w.PrivateKey)
@@ -283,6 +357,7 @@ type txnReceipt struct {
 	Status            *hexutil.Big    `json:"status"`
 	To                *common.Address `json:"to"`
 	TransactionIndex  *hexutil.Uint   `json:"transactionIndex"`
+	Logs              []*types.Log    `json:"logs"`
 }
 
 // WaitUntilMined waits until a given transaction has been mined
@@ -306,9 +381,8 @@ func (w *Worker) waitUntilMined(start time.Time, txHash string, retryDelay time.
 		if receipt.Status != nil {
 			status := receipt.Status.ToInt()
 			w.incrCounter("tx.receipt")
-			if status.Uint64() == 1 {
-				w.incrCounter("tx.success")
-			} else {
+			// Successful transactions are counted once their events are checked
+			if status.Uint64() != 1 {
 				w.incrCounter("tx.failexec")
 				w.incrCounter("tx.fail")
 			}
@@ -323,15 +397,44 @@ func (w *Worker) waitUntilMined(start time.Time, txHash string, retryDelay time.
 			return nil, fmt.Errorf("timed out waiting for TX receipt after %.2fs", elapsed.Seconds())
 		}
 		if !isMined {
-			time.Sleep(retryDelay)
+			w.waitForBlock(retryDelay, time.Duration(w.Exerciser.ReceiptWaitMax)*time.Second-elapsed)
 		}
 	}
 
 	return &receipt, nil
 }
 
-// SendAndWaitForMining sends a single transaction and waits for it to be mined
-func (w *Worker) sendAndWaitForMining(tx *types.Transaction) (*txnReceipt, error) {
+// reportFailedTx logs the reason a mined transaction failed, replaying it to find the revert reason
+func (w *Worker) reportFailedTx(tx *types.Transaction, receipt *txnReceipt) {
+	// If gasUsed == gasProvided, then you ran out of gas
+	if receipt.GasUsed != nil && receipt.GasUsed.ToInt().Uint64() == uint64(w.Exerciser.Gas) {
+		w.error("TX:%s failed. Status=%s. TX ran out of gas before completion.", receipt.TransactionHash.Hex(), receipt.Status.ToInt())
+		w.incrCounter("tx.revert.OutOfGas")
+		return
+	}
+	location := ""
+	if w.Exerciser.TraceFailures {
+		if loc, err := w.traceFailure(receipt); err != nil {
+			w.error("TX:%s could not be traced to the source: %s", receipt.TransactionHash.Hex(), err)
+		} else {
+			location = fmt.Sprintf(" Location=%s", loc)
+		}
+	}
+	reason := w.replayFailedTx(tx, receipt)
+	if reason == nil {
+		w.error("TX:%s failed. Status=%s%s", receipt.TransactionHash.Hex(), receipt.Status.ToInt(), location)
+		w.incrCounter("tx.revert.Unknown")
+		return
+	}
+	w.error("TX:%s failed. Status=%s Reason=%s%s", receipt.TransactionHash.Hex(), receipt.Status.ToInt(), reason, location)
+	w.incrCounter("tx.revert." + reason.Name)
+}
+
+// SendAndWaitForMining sends a single transaction and waits for it to be mined,
+// checking it emitted the expected event if one is supplied
+func (w *Worker) sendAndWaitForMining(tx *types.Transaction, expected *abi.Event) (*txnReceipt, error) {
+	w.subscribeNewHeads()
+	defer w.unsubscribeNewHeads()
 	txHash, err := w.sendTransaction(tx)
 	var receipt *txnReceipt
 	if err != nil {
@@ -340,7 +443,7 @@ func (w *Worker) sendAndWaitForMining(tx *types.Transaction) (*txnReceipt, error
 		// Wait for mining
 		start := time.Now()
 		w.debug("Waiting for %d seconds for tx be mined in next block", w.Exerciser.ReceiptWaitMin)
-		time.Sleep(time.Duration(w.Exerciser.ReceiptWaitMin) * time.Second)
+		w.waitForMining(time.Duration(w.Exerciser.ReceiptWaitMin) * time.Second)
 		receipt, err = w.waitUntilMined(start, txHash, 1*time.Second)
 		if err != nil {
 			return nil, fmt.Errorf("failed checking TX receipt: %s", err)
@@ -348,6 +451,11 @@ func (w *Worker) sendAndWaitForMining(tx *types.Transaction) (*txnReceipt, error
 		// Increase nonce only if we got a receipt.
 		// Known transaction processing will kick in to bump the nonce otherwise
 		w.Nonce++
+		if receipt.Status != nil && receipt.Status.ToInt().Uint64() == 0 {
+			w.reportFailedTx(tx, receipt)
+		} else if !w.checkReceiptEvents(receipt, expected) {
+			return receipt, fmt.Errorf("transaction %s did not emit %s", receipt.TransactionHash.Hex(), expected.Sig)
+		}
 	}
 	return receipt, err
 }
@@ -357,7 +465,7 @@ func (w *Worker) initializeNonce(address common.Address) error {
 	block := "latest"
 	if w.Exerciser.Nonce == -1 {
 		var result hexutil.Uint64
-		err := w.RPC.Call(&result, "eth_getTransactionCount", address.Hex(), block)
+		err := w.rpcCall(&result, "eth_getTransactionCount", address.Hex(), block)
 		if err != nil {
 			return fmt.Errorf("failed to get transaction count '%s' for %s: %s", result, address, err)
 		}
@@ -370,8 +478,9 @@ func (w *Worker) initializeNonce(address common.Address) error {
 }
 
 // Init the account and connection for this worker
-func (w *Worker) Init(rpc *rpc.Client) (err error) {
-	w.RPC = rpc
+func (w *Worker) Init(endpoints *endpointPool) (err error) {
+	w.endpoints = endpoints
+	w.rand = rand.New(rand.NewSource(time.Now().UnixNano() + int64(w.Index)))
 
 	// Store items we need for metrics naming
 	if w.Exerciser.StatsdServer != "" {
@@ -402,26 +511,46 @@ func (w *Worker) Init(rpc *rpc.Client) (err error) {
 	return nil
 }
 
-// InstallContract installs the contract and returns the address
-func (w *Worker) InstallContract() (*common.Address, error) {
+// deployBytecode deploys a contract with the specified bytecode and returns the address
+func (w *Worker) deployBytecode(code []byte) (*common.Address, error) {
 	tx := types.NewContractCreation(
 		w.Nonce,
 		big.NewInt(w.Exerciser.Amount),
 		uint64(w.Exerciser.Gas),
 		big.NewInt(w.Exerciser.GasPrice),
-		common.FromHex(w.CompiledContract.Compiled),
+		code,
 	)
-	receipt, err := w.sendAndWaitForMining(tx)
+	receipt, err := w.sendAndWaitForMining(tx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to install contract: %s", err)
+		return nil, err
 	}
 	return receipt.ContractAddress, nil
 }
 
+// InstallContract installs the contract and returns the address
+func (w *Worker) InstallContract() (*common.Address, error) {
+	if w.Exerciser.libraryLinks == nil {
+		w.Exerciser.libraryLinks = make(map[string]common.Address)
+	}
+	code, err := w.linkLibraries(w.CompiledContract.Compiled, w.Exerciser.libraryLinks, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to link contract: %s", err)
+	}
+	addr, err := w.deployBytecode(append(common.FromHex(code), w.CompiledContract.PackedConstruct...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to install contract: %s", err)
+	}
+	return addr, nil
+}
+
 // CallOnce executes a contract once and returns
 func (w *Worker) CallOnce() error {
-	tx := w.generateTransaction()
-	err := w.callContract(tx)
+	m := w.pickMethod()
+	tx, err := w.generateTransaction(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.callContract(m, tx)
 	return err
 }
 
@@ -430,8 +559,14 @@ func (w *Worker) CallMultiple() {
 	infinite := (w.Exerciser.Loops == 0)
 	for ; w.LoopIndex < uint64(w.Exerciser.Loops) || infinite; w.LoopIndex++ {
 
-		tx := w.generateTransaction()
-		_ = w.callContract(tx)
+		m := w.pickMethod()
+		tx, err := w.generateTransaction(m)
+		if err == nil {
+			_, err = w.callContract(m, tx)
+		}
+		if err != nil {
+			w.error("%s", err)
+		}
 		time.Sleep(time.Duration(w.Exerciser.ReceiptWaitMin) * time.Second)
 	}
 }
@@ -445,14 +580,37 @@ func (w *Worker) Run() {
 	for ; w.LoopIndex < uint64(w.Exerciser.Loops) || infinite; w.LoopIndex++ {
 
 		// Send a set of transactions before waiting for receipts (which takes some time)
+		// View methods in a workload mix are called immediately, and count as a success if they return
 		var txHashes []string
+		var loopSuccesses uint64
+		txns := make(map[string]*types.Transaction)
+		txMethods := make(map[string]*workloadMethod)
+		w.subscribeNewHeads()
 		for i := 0; i < w.Exerciser.TxnsPerLoop; i++ {
-			tx := w.generateTransaction()
+			m := w.pickMethod()
+			tx, err := w.generateTransaction(m)
+			if err != nil {
+				w.error("TX generation failed (%d/%d): %s", i, w.Exerciser.TxnsPerLoop, err)
+				continue
+			}
+			if m.Call {
+				if _, err := w.callContract(m, tx); err != nil {
+					w.error("%s", err)
+				} else {
+					loopSuccesses++
+				}
+				continue
+			}
 			txHash, err := w.sendTransaction(tx)
 			if err != nil {
 				w.error("TX send failed (%d/%d): %s", i, w.Exerciser.TxnsPerLoop, err)
+				w.incrMethodCounter(m, "tx.sendfail")
+				w.incrMethodCounter(m, "tx.fail")
 			} else {
+				w.incrMethodCounter(m, "tx.sent")
 				txHashes = append(txHashes, txHash)
+				txns[txHash] = tx
+				txMethods[txHash] = m
 				w.Nonce++
 			}
 		}
@@ -478,14 +636,15 @@ func (w *Worker) Run() {
 		initialSleep := minSleep + retryDelay
 		w.debug("Waiting for %.2fs seconds then retrying every %.2fs", initialSleep.Seconds(), retryDelay.Seconds())
 		start := time.Now()
-		time.Sleep(minSleep)
+		w.waitForMining(minSleep)
 
 		// Wait for the receipts of all successfully set transctions
-		var loopSuccesses uint64
 		for _, txHash := range txHashes {
+			m := txMethods[txHash]
 			receipt, err := w.waitUntilMined(start, txHash, retryDelay)
 			if err != nil {
 				w.error("TX:%s failed checking receipt: %s", txHash, err)
+				w.incrMethodCounter(m, "tx.fail")
 			} else {
 				// Store the mining time for the first successful transaction
 				if w.lastMiningTime == 0 {
@@ -495,16 +654,17 @@ func (w *Worker) Run() {
 				}
 
 				if receipt.Status.ToInt().Uint64() == 0 {
-					w.error("TX:%s failed. Status=%s", txHash, receipt.Status.ToInt())
-					// If gasUsed == gasProvided, then you ran out of gas
-					if receipt.GasUsed.ToInt().Uint64() == uint64(w.Exerciser.Gas) {
-						w.error("TX ran out of gas before completion.")
-					}
-				} else {
+					w.reportFailedTx(txns[txHash], receipt)
+					w.incrMethodCounter(m, "tx.fail")
+				} else if w.checkReceiptEvents(receipt, m.ExpectedEvent) {
+					w.incrMethodCounter(m, "tx.success")
 					loopSuccesses++
+				} else {
+					w.incrMethodCounter(m, "tx.fail")
 				}
 			}
 		}
+		w.unsubscribeNewHeads()
 		var loopFailures = uint64(w.Exerciser.TxnsPerLoop) - loopSuccesses
 
 		// Increment global counters
@@ -514,6 +674,5 @@ func (w *Worker) Run() {
 		atomic.AddUint64(&w.Exerciser.TotalFailures, loopFailures)
 	}
 
-	w.RPC.Close()
 	log.Debug(w.Name, ": finished. Loops=", w.LoopIndex, " Success=", successes, " Failures=", failures)
 }
@@ -0,0 +1,170 @@
+// Copyright 2018 Kaleido, a ConsenSys business
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kldexerciser
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestIsUndelivered(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"dial", dialErr, true},
+		{"http dial", &url.Error{Op: "Post", URL: "http://node", Err: dialErr}, true},
+		{"read", &url.Error{Op: "Post", URL: "http://node", Err: &net.OpError{Op: "read", Err: fmt.Errorf("connection reset")}}, false},
+		{"http status", rpc.HTTPError{StatusCode: http.StatusBadGateway}, false},
+		{"timeout", context.DeadlineExceeded, false},
+	}
+	for _, test := range tests {
+		if isUndelivered(test.err) != test.expected {
+			t.Errorf("%s: expected %t", test.name, test.expected)
+		}
+	}
+}
+
+// failWith makes the node respond to a method with an HTTP status, without processing the request
+func failWith(n *testNode, method string, status int) {
+	n.intercept = func(m string, w http.ResponseWriter) bool {
+		if m != method {
+			return false
+		}
+		w.WriteHeader(status)
+		return true
+	}
+}
+
+func testSendTxArgs() sendTxArgs {
+	data := hexutil.Bytes{0x01}
+	return sendTxArgs{From: "0x0102030405060708090a0b0c0d0e0f1011121314", To: "0x00000000000000000000000000000000000c0de0", Data: &data}
+}
+
+func TestRPCCallFailsOver(t *testing.T) {
+	node1, node2 := newTestNode(t), newTestNode(t)
+	w := newTestWorker(t, newTestExerciser(node1, node2))
+	failWith(node1, "eth_blockNumber", http.StatusBadGateway)
+	var blockNumber hexutil.Uint64
+	if err := w.rpcCall(&blockNumber, "eth_blockNumber"); err != nil {
+		t.Fatal(err)
+	}
+	if node1.requestCount("eth_blockNumber") != maxEndpointFailures || node2.requestCount("eth_blockNumber") != 1 {
+		t.Errorf("expected %d calls to node1 and 1 to node2, got %d and %d", maxEndpointFailures,
+			node1.requestCount("eth_blockNumber"), node2.requestCount("eth_blockNumber"))
+	}
+}
+
+func TestRPCCallDoesNotResendDeliveredTransactions(t *testing.T) {
+	node1, node2 := newTestNode(t), newTestNode(t)
+	w := newTestWorker(t, newTestExerciser(node1, node2))
+	failWith(node1, "eth_sendTransaction", http.StatusBadGateway)
+	var txHash string
+	if err := w.rpcCall(&txHash, "eth_sendTransaction", testSendTxArgs()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if node1.requestCount("eth_sendTransaction") != 1 || node2.requestCount("eth_sendTransaction") != 0 {
+		t.Errorf("transaction sent %d times to node1 and %d to node2",
+			node1.requestCount("eth_sendTransaction"), node2.requestCount("eth_sendTransaction"))
+	}
+}
+
+func TestRPCCallSendsUndeliveredTransactionsToAnotherNode(t *testing.T) {
+	node2 := newTestNode(t)
+	e := newTestExerciser(node2)
+	// Connections to the first node are refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	e.URLs = append([]string{"http://" + listener.Addr().String()}, e.URLs...)
+	w := newTestWorker(t, e)
+	var txHash string
+	if err := w.rpcCall(&txHash, "eth_sendTransaction", testSendTxArgs()); err != nil {
+		t.Fatal(err)
+	}
+	if node2.requestCount("eth_sendTransaction") != 1 || len(node2.sent) != 1 {
+		t.Errorf("transaction not sent to node2")
+	}
+}
+
+func TestRPCCallTimeoutIncludesRetries(t *testing.T) {
+	node := newTestNode(t)
+	e := newTestExerciser(node)
+	e.RPCTimeout = 1
+	w := newTestWorker(t, e)
+	failWith(node, "eth_blockNumber", http.StatusTooManyRequests)
+	start := time.Now()
+	var blockNumber hexutil.Uint64
+	err := w.rpcCall(&blockNumber, "eth_blockNumber")
+	if err == nil || !strings.Contains(err.Error(), "eth_blockNumber timed out after 1s retrying: 429") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("retries took %s, beyond the 1s timeout", elapsed)
+	}
+	if node.requestCount("eth_blockNumber") < 2 {
+		t.Errorf("expected the rate limited call to be retried")
+	}
+}
+
+func TestRPCCallTimeoutAcrossNodes(t *testing.T) {
+	node1, node2 := newTestNode(t), newTestNode(t)
+	e := newTestExerciser(node1, node2)
+	e.RPCTimeout = 1
+	w := newTestWorker(t, e)
+	for _, n := range []*testNode{node1, node2} {
+		n.intercept = func(m string, w http.ResponseWriter) bool {
+			if m == "eth_blockNumber" {
+				time.Sleep(700 * time.Millisecond)
+				w.WriteHeader(http.StatusBadGateway)
+				return true
+			}
+			return false
+		}
+	}
+	start := time.Now()
+	var blockNumber hexutil.Uint64
+	if err := w.rpcCall(&blockNumber, "eth_blockNumber"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("failover took %s, beyond the 1s timeout", elapsed)
+	}
+}
+
+func TestInstallContractAppendsConstructorArgs(t *testing.T) {
+	node := newTestNode(t)
+	w := newTestWorker(t, newTestExerciser(node))
+	w.CompiledContract = &CompiledSolidity{Compiled: "0x6080", PackedConstruct: []byte{0x01, 0x02}}
+	if _, err := w.InstallContract(); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.sent) != 1 || node.sent[0].String() != "0x60800102" {
+		t.Errorf("unexpected deployment %v", node.sent)
+	}
+}